@@ -0,0 +1,57 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// inspectAffected makes a last, best-effort attempt at inspecting the
+// affected containers once the run's checks are done. It uses a client
+// of its own, so that connections stuck in hung calls don't get in the
+// way, records the containers' healthcheck state and saves their
+// inspect documents. It returns the paths of the saved documents by
+// container ID.
+func inspectAffected(conts []*docker.Container) map[string]string {
+	saved := map[string]string{}
+	client, err := newClient()
+	if err != nil {
+		logger.WithError(err).Warn("Could not create a client to inspect the affected containers")
+		return saved
+	}
+	timeout := time.Duration(callTimeoutSecs) * time.Second
+	for _, cont := range conts {
+		clog := logger.WithField("container_id", cont.ID)
+		ctx, cancel := context.WithTimeout(rootCtx, timeout)
+		insp, err := client.InspectContainerWithContext(cont.ID, ctx)
+		cancel()
+		if err != nil {
+			clog.WithError(err).Warn("Could not inspect affected container")
+			continue
+		}
+		results.recordHealthState(cont.ID, insp.State.Health)
+		if err := writeInspect(insp); err != nil {
+			clog.WithError(err).Warn("Could not save inspect document")
+			continue
+		}
+		saved[cont.ID] = runPath("inspect", cont.ID+".json")
+		clog.Info("Inspected affected container")
+	}
+	return saved
+}
@@ -0,0 +1,211 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/pkg/errors"
+)
+
+var useBuildKit bool
+
+// buildKitMessage is a line of the daemon's build progress. BuildKit
+// reports its progress as aux messages, which are only passed through.
+type buildKitMessage struct {
+	Stream string `json:"stream"`
+	Error  string `json:"error"`
+}
+
+// Builders an image can be built with, as recorded in the results.
+const (
+	builderLegacy   = "legacy"
+	builderBuildKit = "buildkit"
+)
+
+// buildKitRefused is the daemon turning a BuildKit build down, rather
+// than the build itself failing.
+type buildKitRefused struct {
+	err error
+}
+
+func (e buildKitRefused) Error() string {
+	return e.err.Error()
+}
+
+// daemonBuilderVersion returns the builder version the daemon advertises
+// in its ping, "2" if it builds with BuildKit. Daemons that predate
+// BuildKit advertise none.
+func daemonBuilderVersion(ctx context.Context, client *docker.Client) (string, error) {
+	u, err := daemonURL(client, "/_ping")
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET /_ping: %s", resp.Status)
+	}
+	return resp.Header.Get("Builder-Version"), nil
+}
+
+// buildWithBuildKit builds the image described by opts with the
+// daemon's BuildKit builder instead of the legacy one. The client has
+// no support for it, so the request is made by hand. The build context
+// is uploaded with the request rather than served over a session, which
+// daemons that insist on a session refuse with a buildKitRefused. A
+// context directory's .dockerignore isn't applied.
+func buildWithBuildKit(client *docker.Client, opts docker.BuildImageOptions) error {
+	input := opts.InputStream
+	if opts.ContextDir != "" {
+		buf, err := tarContext(opts.ContextDir)
+		if err != nil {
+			return errors.Wrap(err, "could not archive build context")
+		}
+		input = buf
+	}
+
+	u, err := daemonURL(client, "/build")
+	if err != nil {
+		return err
+	}
+	labels, err := json.Marshal(opts.Labels)
+	if err != nil {
+		return err
+	}
+	q := url.Values{}
+	q.Set("version", "2")
+	q.Set("t", opts.Name)
+	q.Set("labels", string(labels))
+	if opts.Dockerfile != "" {
+		q.Set("dockerfile", opts.Dockerfile)
+	}
+	u += "?" + q.Encode()
+
+	req, err := http.NewRequest("POST", u, input)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-tar")
+	resp, err := client.HTTPClient.Do(req.WithContext(opts.Context))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var body bytes.Buffer
+		io.Copy(&body, io.LimitReader(resp.Body, 4096))
+		return buildKitRefused{fmt.Errorf("POST /build: %s: %s", resp.Status, bytes.TrimSpace(body.Bytes()))}
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg buildKitMessage
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "could not read build progress")
+		}
+		if msg.Error != "" && strings.Contains(strings.ToLower(msg.Error), "session") {
+			return buildKitRefused{errors.New(msg.Error)}
+		}
+		if msg.Error != "" {
+			return errors.New(msg.Error)
+		}
+		if msg.Stream != "" && opts.OutputStream != nil {
+			io.WriteString(opts.OutputStream, msg.Stream)
+		}
+	}
+}
+
+// buildWithFallback builds the image name with BuildKit, and with the
+// legacy builder if the daemon doesn't advertise BuildKit or refuses the
+// build. It returns the builder the image was built with.
+func buildWithFallback(client *docker.Client, name string) (string, error) {
+	blog := logger.WithField("image", name)
+	version, err := daemonBuilderVersion(rootCtx, client)
+	if err != nil {
+		return "", err
+	}
+	if version != "2" {
+		blog.WithField("builder_version", version).Warn("Daemon doesn't advertise BuildKit, building with the legacy builder")
+		return builderLegacy, client.BuildImage(buildImageOptions(name))
+	}
+	err = buildWithBuildKit(client, buildImageOptions(name))
+	if _, ok := err.(buildKitRefused); !ok {
+		return builderBuildKit, err
+	}
+	blog.WithError(err).Warn("Daemon refused the BuildKit build, building with the legacy builder")
+	return builderLegacy, client.BuildImage(buildImageOptions(name))
+}
+
+// tarContext archives the regular files and directories of dir.
+func tarContext(dir string) (*bytes.Buffer, error) {
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if !fi.IsDir() && !fi.Mode().IsRegular() {
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf, tw.Close()
+}
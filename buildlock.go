@@ -0,0 +1,70 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// buildLockPoll is how often a process waiting for another one's build
+// checks whether the lock was released.
+const buildLockPoll = 250 * time.Millisecond
+
+// buildLockPath is the lock file that serializes builds of an image
+// across processes on this host.
+func buildLockPath(image string) string {
+	name := strings.NewReplacer("/", "_", ":", "_").Replace(image)
+	return filepath.Join(os.TempDir(), "health-stats-repro-build-"+name+".lock")
+}
+
+// lockBuild takes the build lock of image, waiting for as long as
+// another process holds it. waited reports whether it had to, in which
+// case the other process has most likely just built the image.
+func lockBuild(ctx context.Context, image string) (unlock func(), waited bool, err error) {
+	f, err := os.OpenFile(buildLockPath(image), os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "could not open build lock")
+	}
+	for {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != syscall.EWOULDBLOCK {
+			f.Close()
+			return nil, false, errors.Wrap(err, "could not take build lock")
+		}
+		if !waited {
+			logger.WithField("lock", f.Name()).Info("Waiting for another process to build the image")
+			waited = true
+		}
+		if err := sleepCtx(ctx, buildLockPoll); err != nil {
+			f.Close()
+			return nil, waited, err
+		}
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, waited, nil
+}
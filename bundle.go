@@ -0,0 +1,93 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var bundleArtifacts bool
+
+// writeBundle writes the run's directory into a single gzipped tarball
+// next to it, ready to attach to an issue, and returns its name.
+func writeBundle() (string, error) {
+	var paths []string
+	err := filepath.Walk(runDir(), func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	name := filepath.Join(runsDir, fmt.Sprintf("%s-%s.tar.gz", toolLabel, runID))
+	f, err := os.Create(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, path := range paths {
+		rel, err := filepath.Rel(runsDir, path)
+		if err != nil {
+			return "", err
+		}
+		if err := addToTar(tw, path, filepath.ToSlash(rel)); err != nil {
+			logger.WithError(err).WithField("path", path).Warn("Could not add artifact to bundle")
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+	return name, f.Close()
+}
+
+func addToTar(tw *tar.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	// The file may still be growing, copy only what the header says.
+	_, err = io.CopyN(tw, f, hdr.Size)
+	return err
+}
@@ -0,0 +1,100 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// Healthcheck cadences of a container.
+const (
+	cadenceOK      = "ok"
+	cadenceDrifted = "drifted"
+	cadenceStopped = "stopped"
+)
+
+// cadenceTolerance is how many healthcheck intervals may pass between
+// two probes of a container, on top of the probe's timeout, before its
+// cadence is flagged. A daemon whose healthcheck scheduler stalls stops
+// probing well before its API hangs.
+var cadenceTolerance float64
+
+// healthcheckProbeCommand returns the command of the healthcheck probes
+// as the daemon names it in their exec_start events.
+func healthcheckProbeCommand() string {
+	test := healthcheckTest()
+	if test[0] == "CMD-SHELL" {
+		return "/bin/sh -c " + test[1]
+	}
+	return strings.Join(test[1:], " ")
+}
+
+// isHealthcheckProbe returns whether an event's action is the exec_start
+// of a healthcheck probe.
+func isHealthcheckProbe(action string) bool {
+	return action == "exec_start: "+healthcheckProbeCommand()
+}
+
+// cadenceChecked returns whether c's healthcheck cadence can be checked.
+// Execs of the exec-flood scenario run the same command as the exec
+// healthcheck, so their events can't be told apart, and containers
+// that chaos, churn or their restarts stop have gaps in their probes by
+// design.
+func (c *containerResult) cadenceChecked() bool {
+	if cadenceTolerance <= 0 || !useHealthchecks || c.Control || chaosRate > 0 {
+		return false
+	}
+	return scenario != scenarioExecFlood && !restartsContainers()
+}
+
+// checkCadence compares the intervals between c's healthcheck probes,
+// up to the time its checks started, against the configured interval.
+// The caller holds r.mu.
+func (c *containerResult) checkCadence(end time.Time) {
+	if !c.cadenceChecked() {
+		return
+	}
+	if checked := c.firstCheck(); !checked.IsZero() {
+		end = checked
+	}
+	allowed := time.Duration(cadenceTolerance*float64(healthcheckInterval)) + healthcheckTimeout
+
+	last := time.Time{}
+	if len(c.HealthTimeline) != 0 && c.HealthTimeline[0].Status == "starting" {
+		last = c.HealthTimeline[0].Time
+	}
+	c.MaxProbeGap = 0
+	c.Cadence = cadenceOK
+	for _, t := range c.HealthProbes {
+		if t.After(end) {
+			break
+		}
+		if !last.IsZero() && t.Sub(last) > c.MaxProbeGap {
+			c.MaxProbeGap = t.Sub(last)
+		}
+		last = t
+	}
+	if c.MaxProbeGap > allowed {
+		c.Cadence = cadenceDrifted
+	}
+	if !last.IsZero() && end.Sub(last) > allowed {
+		c.Cadence = cadenceStopped
+	}
+	if n := len(c.HealthProbes); n > 1 {
+		c.ProbeInterval = c.HealthProbes[n-1].Sub(c.HealthProbes[0]) / time.Duration(n-1)
+	}
+}
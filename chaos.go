@@ -0,0 +1,100 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+var (
+	chaosRate float64
+	chaosSeed int64
+)
+
+// chaosOps are the operations chaos interleaves with the run. A pause
+// is always followed by an unpause, as a paused container can't be
+// killed by the checks at the end of the run.
+var chaosOps = []string{"pause", "restart", "kill", "rename"}
+
+// chaos makes a random operation against a random container of conts,
+// rate times a second, until ctx is done. The same seed makes the same
+// sequence of operations. Each call is bounded by the call timeout and
+// a container that hung is left alone.
+func chaos(ctx context.Context, client *docker.Client, conts []*docker.Container, rate float64, seed int64) {
+	rng := rand.New(rand.NewSource(seed))
+	callTimeout := time.Duration(callTimeoutSecs) * time.Second
+	tick := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer tick.Stop()
+	for n := 0; ; n++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		cont := conts[rng.Intn(len(conts))]
+		op := chaosOps[rng.Intn(len(chaosOps))]
+		if isAffected(cont.ID) {
+			continue
+		}
+
+		calls := map[string]func(ctx context.Context) error{
+			"pause":   func(context.Context) error { return client.PauseContainer(cont.ID) },
+			"unpause": func(context.Context) error { return client.UnpauseContainer(cont.ID) },
+			"restart": func(context.Context) error { return client.RestartContainer(cont.ID, churnStopGrace) },
+			"kill": func(ctx context.Context) error {
+				return client.KillContainer(docker.KillContainerOptions{ID: cont.ID, Context: ctx})
+			},
+			"rename": func(ctx context.Context) error {
+				name := fmt.Sprintf("health-stats-repro-%s-%d", runID, n)
+				return client.RenameContainer(docker.RenameContainerOptions{ID: cont.ID, Name: name, Context: ctx})
+			},
+		}
+		seq := []string{op}
+		if op == "pause" {
+			seq = append(seq, "unpause")
+		}
+
+		clog := logger.WithField("container_id", cont.ID)
+		for _, op := range seq {
+			// A pause is undone even if the run moved on to its
+			// checks in the meantime.
+			callCtx := ctx
+			if op == "unpause" {
+				callCtx = rootCtx
+			}
+			start := time.Now()
+			err := watchCall(callCtx, cont.ID, op, callTimeout, calls[op])
+			if ctx.Err() != nil && op != "pause" {
+				return
+			}
+			olog := finishOp(clog, cont.ID, op, start, err)
+			if classifyError(err) == errClassTimeout {
+				hangDetected(client, cont, op, err)
+			}
+			if err != nil {
+				// Eg. pausing a container chaos killed before.
+				olog.Debug("Chaos operation failed")
+				break
+			}
+			olog.Debug("Chaos operation")
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// Hang symptoms. A run can show several, eg. an inspect hang while the
+// daemon also stops answering pings.
+const (
+	symptomInspectHang     = "inspect-hang"
+	symptomKillHang        = "kill-hang"
+	symptomStatsStalled    = "stats-stalled"
+	symptomDaemonWedged    = "daemon-unresponsive"
+	symptomRemovalStuck    = "removal-in-progress"
+	symptomInspectOnlyHang = "inspect-only-hang"
+	symptomProcsPiledUp    = "healthcheck-processes-piled-up"
+	symptomExecsLeaked     = "execs-leaked"
+	symptomCadenceStalled  = "healthcheck-cadence-stalled"
+	symptomNetworkHang     = "network-hang"
+	symptomVolumeHang      = "volume-hang"
+	symptomAPIOnlyHang     = "api-only-hang"
+	symptomWorkloadStalled = "workload-stalled"
+)
+
+// statsStallAfter is how long a container may go without a stats sample
+// before its stream counts as stalled.
+const statsStallAfter = 5 * time.Second
+
+// classify names the symptoms the run's failures showed, so that
+// different repro signatures aren't conflated. The caller holds r.mu.
+func (r *runResult) classify() []string {
+	var symptoms []string
+	has := map[string]bool{}
+	add := func(s string) {
+		if !has[s] {
+			has[s] = true
+			symptoms = append(symptoms, s)
+		}
+	}
+
+	var firstHang time.Time
+	for _, call := range r.HungCalls {
+		switch call.Op {
+		case "inspect", "verify", "workload-port":
+			add(symptomInspectHang)
+		case "kill", "stop", "force-remove":
+			add(symptomKillHang)
+		case "auto-remove":
+			add(symptomRemovalStuck)
+		case "network-create", "network-connect", "network-disconnect", "network-remove":
+			add(symptomNetworkHang)
+		case "volume-create", "volume-remove":
+			add(symptomVolumeHang)
+		}
+		if firstHang.IsZero() || call.Start.Before(firstHang) {
+			firstHang = call.Start
+		}
+	}
+
+	for _, c := range r.Containers {
+		for _, op := range c.Ops {
+			if op.Op == "remove" && strings.Contains(op.Error, "already in progress") {
+				add(symptomRemovalStuck)
+			}
+		}
+		if c.ProcessesPiledUp {
+			add(symptomProcsPiledUp)
+		}
+		if c.ExecsLeaked {
+			add(symptomExecsLeaked)
+		}
+		if c.Cadence == cadenceDrifted || c.Cadence == cadenceStopped {
+			add(symptomCadenceStalled)
+		}
+		if _, stalled := c.statsStalled(); stalled {
+			add(symptomStatsStalled)
+		}
+		if hang := r.firstHangOf(c.ID); !hang.IsZero() {
+			answered, probed := c.workloadAfterHang(hang)
+			switch {
+			case probed == 0:
+			case answered == 0:
+				add(symptomWorkloadStalled)
+			default:
+				add(symptomAPIOnlyHang)
+			}
+		}
+	}
+
+	// Pings failing once calls started hanging point at the daemon as
+	// a whole rather than at the hung containers.
+	if !firstHang.IsZero() {
+		for _, p := range r.Pings {
+			if p.Error != "" && !p.Time.Before(firstHang) {
+				add(symptomDaemonWedged)
+				break
+			}
+		}
+	}
+
+	if has[symptomInspectHang] && !has[symptomKillHang] && !has[symptomDaemonWedged] {
+		add(symptomInspectOnlyHang)
+	}
+	return symptoms
+}
+
+// statsStalled returns whether c's stats stream stalled, and for how
+// long it had been quiet when the checks started. Killing a container
+// ends its stream, only a stream that went quiet before that stalled.
+func (c *containerResult) statsStalled() (time.Duration, bool) {
+	if c.StatsSamples == 0 || c.LastStatsSample.IsZero() {
+		return 0, false
+	}
+	checked := c.firstCheck()
+	if checked.IsZero() {
+		return 0, false
+	}
+	quiet := checked.Sub(c.LastStatsSample)
+	return quiet, quiet > statsStallAfter
+}
+
+// firstCheck returns when the first kill, stop or inspect of c started.
+func (c *containerResult) firstCheck() time.Time {
+	var first time.Time
+	for _, op := range c.Ops {
+		if op.Op != "kill" && op.Op != "stop" && op.Op != "inspect" {
+			continue
+		}
+		if first.IsZero() || op.Start.Before(first) {
+			first = op.Start
+		}
+	}
+	return first
+}
+
+// firstHangOf returns when the first call against the container that
+// hung started, zero if none did. The caller holds r.mu.
+func (r *runResult) firstHangOf(id string) time.Time {
+	var first time.Time
+	for _, call := range r.HungCalls {
+		if call.ContainerID == id && (first.IsZero() || call.Start.Before(first)) {
+			first = call.Start
+		}
+	}
+	return first
+}
@@ -0,0 +1,174 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"os"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/sirupsen/logrus"
+)
+
+// imageNames are the tags the tool builds its test images under. Images
+// built before they were labeled are only found by these.
+var imageNames = []string{
+	"docker-poke:healthchecks",
+	"docker-poke:no-healthchecks",
+	customImageName,
+}
+
+// customImageName is the tag images built from a --dockerfile are built
+// under.
+const customImageName = "docker-poke:custom"
+
+// cleanCommand removes the containers, networks, volumes and images left
+// behind by previous runs, found by their labels.
+func cleanCommand(args []string) {
+	fs := flag.NewFlagSet("clean", flag.ExitOnError)
+	force := fs.Bool("force", false, "Force removal of running containers")
+	run := fs.String("run", "", "Only remove containers created by this run ID")
+	images := fs.Bool("images", true, "Also remove the test images")
+	fs.Parse(args)
+
+	cl, err := newClient()
+	if err != nil {
+		logger.WithError(err).Fatal("Could not create client")
+	}
+
+	filter := runLabel
+	if *run != "" {
+		filter += "=" + *run
+	}
+	conts, err := cl.ListContainers(docker.ListContainersOptions{
+		All:     true,
+		Filters: map[string][]string{"label": {filter}},
+	})
+	if err != nil {
+		logger.WithError(err).Fatal("Could not list containers")
+	}
+
+	failed := 0
+	for _, c := range conts {
+		clog := logger.WithFields(logrus.Fields{
+			"container_id":     c.ID,
+			"container_run_id": c.Labels[runLabel],
+		})
+		err := cl.RemoveContainer(docker.RemoveContainerOptions{
+			ID:    c.ID,
+			Force: *force,
+		})
+		if err != nil {
+			clog.WithError(err).Error("Could not remove container")
+			failed++
+			continue
+		}
+		clog.Info("Removed container")
+	}
+
+	failed += cleanNetworks(cl, filter)
+	failed += cleanVolumes(cl, filter)
+	if *images {
+		failed += cleanImages(cl)
+	}
+
+	if failed != 0 {
+		logger.Errorf("Could not remove %d resource(s)", failed)
+		os.Exit(1)
+	}
+}
+
+// cleanNetworks removes the networks runs created for
+// --network-churn-interval, found by filter.
+func cleanNetworks(client *docker.Client, filter string) int {
+	networks, err := client.FilteredListNetworks(docker.NetworkFilterOpts{
+		"label": {filter: true},
+	})
+	if err != nil {
+		logger.WithError(err).Error("Could not list networks")
+		return 1
+	}
+	failed := 0
+	for _, n := range networks {
+		nlog := logger.WithField("network_id", n.ID)
+		if err := client.RemoveNetwork(n.ID); err != nil {
+			nlog.WithError(err).Error("Could not remove network")
+			failed++
+			continue
+		}
+		nlog.Info("Removed network")
+	}
+	return failed
+}
+
+// cleanVolumes removes the volumes runs created for --volume-ops, found
+// by filter.
+func cleanVolumes(client *docker.Client, filter string) int {
+	volumes, err := client.ListVolumes(docker.ListVolumesOptions{
+		Filters: map[string][]string{"label": {filter}},
+	})
+	if err != nil {
+		logger.WithError(err).Error("Could not list volumes")
+		return 1
+	}
+	failed := 0
+	for _, v := range volumes {
+		vlog := logger.WithField("volume", v.Name)
+		if err := client.RemoveVolume(v.Name); err != nil {
+			vlog.WithError(err).Error("Could not remove volume")
+			failed++
+			continue
+		}
+		vlog.Info("Removed volume")
+	}
+	return failed
+}
+
+func cleanImages(client *docker.Client) int {
+	refs := append([]string(nil), imageNames...)
+	labeled, err := client.ListImages(docker.ListImagesOptions{
+		Filters: map[string][]string{"label": {toolLabel}},
+	})
+	if err != nil {
+		logger.WithError(err).Error("Could not list labeled images")
+		return 1
+	}
+	for _, img := range labeled {
+		refs = append(refs, img.ID)
+	}
+
+	failed := 0
+	seen := map[string]bool{}
+	for _, ref := range refs {
+		if seen[ref] {
+			continue
+		}
+		seen[ref] = true
+
+		ilog := logger.WithField("image", ref)
+		err := client.RemoveImage(ref)
+		switch err {
+		case nil:
+			ilog.Info("Removed image")
+		case docker.ErrNoSuchImage:
+			ilog.Debug("Image is already gone")
+		default:
+			ilog.WithError(err).Error("Could not remove image")
+			failed++
+		}
+	}
+	return failed
+}
@@ -0,0 +1,239 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	throttleRetries int
+	throttleMaxWait time.Duration
+
+	// throttledResponses counts every 429/503 response seen this run.
+	throttledResponses int64
+)
+
+// newClient returns a client configured from the environment, with the
+// retry layer for throttled requests installed.
+func newClient() (*docker.Client, error) {
+	client, err := docker.NewClientFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	base := client.HTTPClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if apiLimiter != nil {
+		base = &limitTransport{base: base}
+	}
+	client.HTTPClient.Transport = &throttleTransport{
+		base:       base,
+		maxRetries: throttleRetries,
+		maxWait:    throttleMaxWait,
+	}
+	return client, nil
+}
+
+// throttleTransport retries requests that a managed or proxied daemon
+// turned away with 429 or 503 and a Retry-After, after waiting out the
+// advertised delay, as long as the request's context allows for it.
+// Streams that go through a hijacked connection don't pass through
+// here.
+type throttleTransport struct {
+	base       http.RoundTripper
+	maxRetries int
+	maxWait    time.Duration
+}
+
+func (t *throttleTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if err != nil || !isThrottleStatus(resp.StatusCode) {
+			return resp, err
+		}
+		wait, ok := retryAfter(resp, time.Now())
+		if !ok {
+			// A 503 without Retry-After is the daemon failing, not
+			// shedding load.
+			return resp, nil
+		}
+		atomic.AddInt64(&throttledResponses, 1)
+		throttleRecordFrom(ctx).add(wait)
+
+		rlog := logger.WithFields(logrus.Fields{
+			"method":      req.Method,
+			"path":        req.URL.Path,
+			"status":      resp.StatusCode,
+			"retry_after": wait,
+			"attempt":     attempt + 1,
+		})
+		if attempt >= t.maxRetries || wait > t.maxWait || !fitsDeadline(ctx, wait) || (req.Body != nil && req.GetBody == nil) {
+			rlog.Warn("Daemon throttled request, giving up")
+			return resp, nil
+		}
+		rlog.Warn("Daemon throttled request, retrying")
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.WithContext(ctx)
+			req.Body = body
+		}
+	}
+}
+
+func isThrottleStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
+
+// retryAfter returns how long the response asks the client to back off.
+// A 429 without a usable Retry-After still means back off, so a second
+// is assumed.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	header := resp.Header.Get("Retry-After")
+	if secs, err := strconv.Atoi(header); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		if wait := at.Sub(now); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return time.Second, true
+	}
+	return 0, false
+}
+
+func fitsDeadline(ctx context.Context, wait time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	return !ok || time.Until(deadline) > wait
+}
+
+// throttleRecord collects the throttling a single call ran into, so
+// that a call that timed out while backing off isn't reported as a
+// hang.
+type throttleRecord struct {
+	mu     sync.Mutex
+	count  int
+	waited time.Duration
+}
+
+type throttleRecordKey struct{}
+
+func withThrottleRecord(ctx context.Context) (context.Context, *throttleRecord) {
+	rec := &throttleRecord{}
+	return context.WithValue(ctx, throttleRecordKey{}, rec), rec
+}
+
+func throttleRecordFrom(ctx context.Context) *throttleRecord {
+	rec, _ := ctx.Value(throttleRecordKey{}).(*throttleRecord)
+	return rec
+}
+
+func (r *throttleRecord) add(wait time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.count++
+	r.waited += wait
+}
+
+func (r *throttleRecord) throttled() (int, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.count, r.waited
+}
+
+// daemonURL returns the URL of path on the client's daemon, for
+// endpoints the client has no method for. Requests to unix sockets are
+// dialed by the client's own transport, so only the path of such a URL
+// matters.
+func daemonURL(client *docker.Client, path string) (string, error) {
+	u, err := url.Parse(client.Endpoint())
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "unix":
+		u.Host = "unix.sock"
+		u.Scheme = "http"
+	case "tcp":
+		u.Scheme = "http"
+		if client.TLSConfig != nil {
+			u.Scheme = "https"
+		}
+	case "http", "https":
+	default:
+		return "", fmt.Errorf("requests over %s endpoints are not supported", u.Scheme)
+	}
+	u.Path = path
+	return u.String(), nil
+}
+
+// getDaemonJSON returns the raw JSON response to a GET of path.
+func getDaemonJSON(ctx context.Context, client *docker.Client, path string) (json.RawMessage, error) {
+	u, err := daemonURL(client, path)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"time"
+)
+
+const journalTimeout = 30 * time.Second
+
+var (
+	collectDaemonLogs bool
+	daemonConfigPath  string
+	daemonUnit        string
+)
+
+// collectDaemonSide copies the daemon's configuration and its journal
+// since the run started into the run's daemon directory, so that the
+// daemon's view of a hang ships with the report. Each part is best
+// effort.
+func collectDaemonSide() {
+	if b, err := ioutil.ReadFile(daemonConfigPath); err != nil {
+		logger.WithError(err).Warn("Could not read daemon configuration")
+	} else {
+		name := runPath("daemon", "daemon.json")
+		if err := ioutil.WriteFile(name, b, 0640); err != nil {
+			logger.WithError(err).Warn("Could not save daemon configuration")
+		} else {
+			logger.WithField("path", name).Info("Saved daemon configuration")
+		}
+	}
+
+	name := runPath("daemon", fmt.Sprintf("journal-%s.log", daemonUnit))
+	if err := saveJournal(name, daemonUnit, results.Start); err != nil {
+		logger.WithError(err).Warn("Could not save daemon journal")
+	} else {
+		logger.WithField("path", name).Info("Saved daemon journal")
+	}
+}
+
+// saveJournal writes unit's journal since since to name.
+func saveJournal(name, unit string, since time.Time) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), journalTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "journalctl",
+		"--unit", unit,
+		"--since", since.Format("2006-01-02 15:04:05"),
+		"--output", "short-precise",
+		"--no-pager")
+	cmd.Stdout = f
+	cmd.Stderr = f
+	return cmd.Run()
+}
@@ -0,0 +1,677 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: control.proto
+
+package control
+
+/*
+Package control is the gRPC flavor of the runner's control API. It
+mirrors the HTTP API served by `repro-runner serve`.
+*/
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+import timestamp "github.com/golang/protobuf/ptypes/timestamp"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+
+type StartRunRequest struct {
+	// Flags are the run's flags by name, without leading dashes.
+	Flags                map[string]string `protobuf:"bytes,1,rep,name=flags" json:"flags,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *StartRunRequest) Reset()         { *m = StartRunRequest{} }
+func (m *StartRunRequest) String() string { return proto.CompactTextString(m) }
+func (*StartRunRequest) ProtoMessage()    {}
+func (*StartRunRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_control_6a3d4d1f9df7600d, []int{0}
+}
+func (m *StartRunRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_StartRunRequest.Unmarshal(m, b)
+}
+func (m *StartRunRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_StartRunRequest.Marshal(b, m, deterministic)
+}
+func (dst *StartRunRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StartRunRequest.Merge(dst, src)
+}
+func (m *StartRunRequest) XXX_Size() int {
+	return xxx_messageInfo_StartRunRequest.Size(m)
+}
+func (m *StartRunRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_StartRunRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StartRunRequest proto.InternalMessageInfo
+
+func (m *StartRunRequest) GetFlags() map[string]string {
+	if m != nil {
+		return m.Flags
+	}
+	return nil
+}
+
+type RunRequest struct {
+	RunId                string   `protobuf:"bytes,1,opt,name=run_id,json=runId" json:"run_id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RunRequest) Reset()         { *m = RunRequest{} }
+func (m *RunRequest) String() string { return proto.CompactTextString(m) }
+func (*RunRequest) ProtoMessage()    {}
+func (*RunRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_control_6a3d4d1f9df7600d, []int{1}
+}
+func (m *RunRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RunRequest.Unmarshal(m, b)
+}
+func (m *RunRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RunRequest.Marshal(b, m, deterministic)
+}
+func (dst *RunRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RunRequest.Merge(dst, src)
+}
+func (m *RunRequest) XXX_Size() int {
+	return xxx_messageInfo_RunRequest.Size(m)
+}
+func (m *RunRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_RunRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RunRequest proto.InternalMessageInfo
+
+func (m *RunRequest) GetRunId() string {
+	if m != nil {
+		return m.RunId
+	}
+	return ""
+}
+
+// Run is a run started by the server.
+type Run struct {
+	RunId string            `protobuf:"bytes,1,opt,name=run_id,json=runId" json:"run_id,omitempty"`
+	Flags map[string]string `protobuf:"bytes,2,rep,name=flags" json:"flags,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Status is running or finished.
+	Status string               `protobuf:"bytes,3,opt,name=status" json:"status,omitempty"`
+	Start  *timestamp.Timestamp `protobuf:"bytes,4,opt,name=start" json:"start,omitempty"`
+	// End, exit_code and verdict are set once the run finished.
+	End      *timestamp.Timestamp `protobuf:"bytes,5,opt,name=end" json:"end,omitempty"`
+	ExitCode int32                `protobuf:"varint,6,opt,name=exit_code,json=exitCode" json:"exit_code,omitempty"`
+	Verdict  string               `protobuf:"bytes,7,opt,name=verdict" json:"verdict,omitempty"`
+	// Scheduled is set on the runs the server started on its schedule.
+	Scheduled            bool     `protobuf:"varint,8,opt,name=scheduled" json:"scheduled,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Run) Reset()         { *m = Run{} }
+func (m *Run) String() string { return proto.CompactTextString(m) }
+func (*Run) ProtoMessage()    {}
+func (*Run) Descriptor() ([]byte, []int) {
+	return fileDescriptor_control_6a3d4d1f9df7600d, []int{2}
+}
+func (m *Run) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Run.Unmarshal(m, b)
+}
+func (m *Run) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Run.Marshal(b, m, deterministic)
+}
+func (dst *Run) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Run.Merge(dst, src)
+}
+func (m *Run) XXX_Size() int {
+	return xxx_messageInfo_Run.Size(m)
+}
+func (m *Run) XXX_DiscardUnknown() {
+	xxx_messageInfo_Run.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Run proto.InternalMessageInfo
+
+func (m *Run) GetRunId() string {
+	if m != nil {
+		return m.RunId
+	}
+	return ""
+}
+
+func (m *Run) GetFlags() map[string]string {
+	if m != nil {
+		return m.Flags
+	}
+	return nil
+}
+
+func (m *Run) GetStatus() string {
+	if m != nil {
+		return m.Status
+	}
+	return ""
+}
+
+func (m *Run) GetStart() *timestamp.Timestamp {
+	if m != nil {
+		return m.Start
+	}
+	return nil
+}
+
+func (m *Run) GetEnd() *timestamp.Timestamp {
+	if m != nil {
+		return m.End
+	}
+	return nil
+}
+
+func (m *Run) GetExitCode() int32 {
+	if m != nil {
+		return m.ExitCode
+	}
+	return 0
+}
+
+func (m *Run) GetVerdict() string {
+	if m != nil {
+		return m.Verdict
+	}
+	return ""
+}
+
+func (m *Run) GetScheduled() bool {
+	if m != nil {
+		return m.Scheduled
+	}
+	return false
+}
+
+// ProgressEvent is a step of a run's progress.
+type ProgressEvent struct {
+	// Types that are valid to be assigned to Event:
+	//	*ProgressEvent_Log
+	//	*ProgressEvent_Output
+	//	*ProgressEvent_Run
+	Event                isProgressEvent_Event `protobuf_oneof:"event"`
+	XXX_NoUnkeyedLiteral struct{}              `json:"-"`
+	XXX_unrecognized     []byte                `json:"-"`
+	XXX_sizecache        int32                 `json:"-"`
+}
+
+func (m *ProgressEvent) Reset()         { *m = ProgressEvent{} }
+func (m *ProgressEvent) String() string { return proto.CompactTextString(m) }
+func (*ProgressEvent) ProtoMessage()    {}
+func (*ProgressEvent) Descriptor() ([]byte, []int) {
+	return fileDescriptor_control_6a3d4d1f9df7600d, []int{3}
+}
+func (m *ProgressEvent) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ProgressEvent.Unmarshal(m, b)
+}
+func (m *ProgressEvent) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ProgressEvent.Marshal(b, m, deterministic)
+}
+func (dst *ProgressEvent) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ProgressEvent.Merge(dst, src)
+}
+func (m *ProgressEvent) XXX_Size() int {
+	return xxx_messageInfo_ProgressEvent.Size(m)
+}
+func (m *ProgressEvent) XXX_DiscardUnknown() {
+	xxx_messageInfo_ProgressEvent.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ProgressEvent proto.InternalMessageInfo
+
+type isProgressEvent_Event interface {
+	isProgressEvent_Event()
+}
+
+type ProgressEvent_Log struct {
+	Log string `protobuf:"bytes,1,opt,name=log,oneof"`
+}
+type ProgressEvent_Output struct {
+	Output string `protobuf:"bytes,2,opt,name=output,oneof"`
+}
+type ProgressEvent_Run struct {
+	Run *Run `protobuf:"bytes,3,opt,name=run,oneof"`
+}
+
+func (*ProgressEvent_Log) isProgressEvent_Event()    {}
+func (*ProgressEvent_Output) isProgressEvent_Event() {}
+func (*ProgressEvent_Run) isProgressEvent_Event()    {}
+
+func (m *ProgressEvent) GetEvent() isProgressEvent_Event {
+	if m != nil {
+		return m.Event
+	}
+	return nil
+}
+
+func (m *ProgressEvent) GetLog() string {
+	if x, ok := m.GetEvent().(*ProgressEvent_Log); ok {
+		return x.Log
+	}
+	return ""
+}
+
+func (m *ProgressEvent) GetOutput() string {
+	if x, ok := m.GetEvent().(*ProgressEvent_Output); ok {
+		return x.Output
+	}
+	return ""
+}
+
+func (m *ProgressEvent) GetRun() *Run {
+	if x, ok := m.GetEvent().(*ProgressEvent_Run); ok {
+		return x.Run
+	}
+	return nil
+}
+
+// XXX_OneofFuncs is for the internal use of the proto package.
+func (*ProgressEvent) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error, func(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error), func(msg proto.Message) (n int), []interface{}) {
+	return _ProgressEvent_OneofMarshaler, _ProgressEvent_OneofUnmarshaler, _ProgressEvent_OneofSizer, []interface{}{
+		(*ProgressEvent_Log)(nil),
+		(*ProgressEvent_Output)(nil),
+		(*ProgressEvent_Run)(nil),
+	}
+}
+
+func _ProgressEvent_OneofMarshaler(msg proto.Message, b *proto.Buffer) error {
+	m := msg.(*ProgressEvent)
+	// event
+	switch x := m.Event.(type) {
+	case *ProgressEvent_Log:
+		b.EncodeVarint(1<<3 | proto.WireBytes)
+		b.EncodeStringBytes(x.Log)
+	case *ProgressEvent_Output:
+		b.EncodeVarint(2<<3 | proto.WireBytes)
+		b.EncodeStringBytes(x.Output)
+	case *ProgressEvent_Run:
+		b.EncodeVarint(3<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.Run); err != nil {
+			return err
+		}
+	case nil:
+	default:
+		return fmt.Errorf("ProgressEvent.Event has unexpected type %T", x)
+	}
+	return nil
+}
+
+func _ProgressEvent_OneofUnmarshaler(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error) {
+	m := msg.(*ProgressEvent)
+	switch tag {
+	case 1: // event.log
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeStringBytes()
+		m.Event = &ProgressEvent_Log{x}
+		return true, err
+	case 2: // event.output
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeStringBytes()
+		m.Event = &ProgressEvent_Output{x}
+		return true, err
+	case 3: // event.run
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(Run)
+		err := b.DecodeMessage(msg)
+		m.Event = &ProgressEvent_Run{msg}
+		return true, err
+	default:
+		return false, nil
+	}
+}
+
+func _ProgressEvent_OneofSizer(msg proto.Message) (n int) {
+	m := msg.(*ProgressEvent)
+	// event
+	switch x := m.Event.(type) {
+	case *ProgressEvent_Log:
+		n += 1 // tag and wire
+		n += proto.SizeVarint(uint64(len(x.Log)))
+		n += len(x.Log)
+	case *ProgressEvent_Output:
+		n += 1 // tag and wire
+		n += proto.SizeVarint(uint64(len(x.Output)))
+		n += len(x.Output)
+	case *ProgressEvent_Run:
+		s := proto.Size(x.Run)
+		n += 1 // tag and wire
+		n += proto.SizeVarint(uint64(s))
+		n += s
+	case nil:
+	default:
+		panic(fmt.Sprintf("proto: unexpected type %T in oneof", x))
+	}
+	return n
+}
+
+type Result struct {
+	Run *Run `protobuf:"bytes,1,opt,name=run" json:"run,omitempty"`
+	// ResultsJson is the run's results.json.
+	ResultsJson          []byte   `protobuf:"bytes,2,opt,name=results_json,json=resultsJson,proto3" json:"results_json,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Result) Reset()         { *m = Result{} }
+func (m *Result) String() string { return proto.CompactTextString(m) }
+func (*Result) ProtoMessage()    {}
+func (*Result) Descriptor() ([]byte, []int) {
+	return fileDescriptor_control_6a3d4d1f9df7600d, []int{4}
+}
+func (m *Result) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Result.Unmarshal(m, b)
+}
+func (m *Result) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Result.Marshal(b, m, deterministic)
+}
+func (dst *Result) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Result.Merge(dst, src)
+}
+func (m *Result) XXX_Size() int {
+	return xxx_messageInfo_Result.Size(m)
+}
+func (m *Result) XXX_DiscardUnknown() {
+	xxx_messageInfo_Result.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Result proto.InternalMessageInfo
+
+func (m *Result) GetRun() *Run {
+	if m != nil {
+		return m.Run
+	}
+	return nil
+}
+
+func (m *Result) GetResultsJson() []byte {
+	if m != nil {
+		return m.ResultsJson
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*StartRunRequest)(nil), "control.StartRunRequest")
+	proto.RegisterMapType((map[string]string)(nil), "control.StartRunRequest.FlagsEntry")
+	proto.RegisterType((*RunRequest)(nil), "control.RunRequest")
+	proto.RegisterType((*Run)(nil), "control.Run")
+	proto.RegisterMapType((map[string]string)(nil), "control.Run.FlagsEntry")
+	proto.RegisterType((*ProgressEvent)(nil), "control.ProgressEvent")
+	proto.RegisterType((*Result)(nil), "control.Result")
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// Client API for Control service
+
+type ControlClient interface {
+	// StartRun starts a run with the given run flags.
+	StartRun(ctx context.Context, in *StartRunRequest, opts ...grpc.CallOption) (*Run, error)
+	// StreamProgress streams a run's progress until it finishes, ending
+	// with its final status.
+	StreamProgress(ctx context.Context, in *RunRequest, opts ...grpc.CallOption) (Control_StreamProgressClient, error)
+	// GetResult returns a finished run's results.
+	GetResult(ctx context.Context, in *RunRequest, opts ...grpc.CallOption) (*Result, error)
+	// Cancel interrupts a running run, which tears it down as it would on
+	// Ctrl-C.
+	Cancel(ctx context.Context, in *RunRequest, opts ...grpc.CallOption) (*Run, error)
+}
+
+type controlClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewControlClient(cc *grpc.ClientConn) ControlClient {
+	return &controlClient{cc}
+}
+
+func (c *controlClient) StartRun(ctx context.Context, in *StartRunRequest, opts ...grpc.CallOption) (*Run, error) {
+	out := new(Run)
+	err := grpc.Invoke(ctx, "/control.Control/StartRun", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) StreamProgress(ctx context.Context, in *RunRequest, opts ...grpc.CallOption) (Control_StreamProgressClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Control_serviceDesc.Streams[0], c.cc, "/control.Control/StreamProgress", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &controlStreamProgressClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Control_StreamProgressClient interface {
+	Recv() (*ProgressEvent, error)
+	grpc.ClientStream
+}
+
+type controlStreamProgressClient struct {
+	grpc.ClientStream
+}
+
+func (x *controlStreamProgressClient) Recv() (*ProgressEvent, error) {
+	m := new(ProgressEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *controlClient) GetResult(ctx context.Context, in *RunRequest, opts ...grpc.CallOption) (*Result, error) {
+	out := new(Result)
+	err := grpc.Invoke(ctx, "/control.Control/GetResult", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) Cancel(ctx context.Context, in *RunRequest, opts ...grpc.CallOption) (*Run, error) {
+	out := new(Run)
+	err := grpc.Invoke(ctx, "/control.Control/Cancel", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for Control service
+
+type ControlServer interface {
+	// StartRun starts a run with the given run flags.
+	StartRun(context.Context, *StartRunRequest) (*Run, error)
+	// StreamProgress streams a run's progress until it finishes, ending
+	// with its final status.
+	StreamProgress(*RunRequest, Control_StreamProgressServer) error
+	// GetResult returns a finished run's results.
+	GetResult(context.Context, *RunRequest) (*Result, error)
+	// Cancel interrupts a running run, which tears it down as it would on
+	// Ctrl-C.
+	Cancel(context.Context, *RunRequest) (*Run, error)
+}
+
+func RegisterControlServer(s *grpc.Server, srv ControlServer) {
+	s.RegisterService(&_Control_serviceDesc, srv)
+}
+
+func _Control_StartRun_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartRunRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).StartRun(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/control.Control/StartRun",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).StartRun(ctx, req.(*StartRunRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_StreamProgress_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(RunRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ControlServer).StreamProgress(m, &controlStreamProgressServer{stream})
+}
+
+type Control_StreamProgressServer interface {
+	Send(*ProgressEvent) error
+	grpc.ServerStream
+}
+
+type controlStreamProgressServer struct {
+	grpc.ServerStream
+}
+
+func (x *controlStreamProgressServer) Send(m *ProgressEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Control_GetResult_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RunRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).GetResult(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/control.Control/GetResult",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).GetResult(ctx, req.(*RunRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_Cancel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RunRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).Cancel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/control.Control/Cancel",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).Cancel(ctx, req.(*RunRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Control_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "control.Control",
+	HandlerType: (*ControlServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "StartRun",
+			Handler:    _Control_StartRun_Handler,
+		},
+		{
+			MethodName: "GetResult",
+			Handler:    _Control_GetResult_Handler,
+		},
+		{
+			MethodName: "Cancel",
+			Handler:    _Control_Cancel_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamProgress",
+			Handler:       _Control_StreamProgress_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "control.proto",
+}
+
+func init() { proto.RegisterFile("control.proto", fileDescriptor_control_6a3d4d1f9df7600d) }
+
+var fileDescriptor_control_6a3d4d1f9df7600d = []byte{
+	// 496 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xa4, 0x53, 0xcd, 0x6e, 0xd3, 0x4c,
+	0x14, 0xed, 0xc4, 0x9f, 0x9d, 0xe4, 0x26, 0xfd, 0x8a, 0x2e, 0x50, 0x46, 0x01, 0x81, 0x49, 0x37,
+	0x91, 0x00, 0xb7, 0x84, 0x4d, 0x61, 0x83, 0xd4, 0xa8, 0x50, 0x60, 0x83, 0xa6, 0xac, 0xd8, 0x44,
+	0x6e, 0x7c, 0x9b, 0x06, 0x9c, 0x99, 0x30, 0x3f, 0x11, 0x7d, 0x01, 0x1e, 0x90, 0x07, 0xe0, 0x59,
+	0x90, 0x3d, 0x76, 0xd3, 0xd0, 0x56, 0x2c, 0xd8, 0xe5, 0x9c, 0x9c, 0x73, 0xe7, 0xdc, 0xa3, 0x6b,
+	0xd8, 0x9c, 0x28, 0x69, 0xb5, 0xca, 0x93, 0x85, 0x56, 0x56, 0x61, 0xb3, 0x82, 0xbd, 0x47, 0x53,
+	0xa5, 0xa6, 0x39, 0xed, 0x96, 0xf4, 0x89, 0x3b, 0xdd, 0xb5, 0xb3, 0x39, 0x19, 0x9b, 0xce, 0x17,
+	0x5e, 0xd9, 0xff, 0xc1, 0x60, 0xeb, 0xd8, 0xa6, 0xda, 0x0a, 0x27, 0x05, 0x7d, 0x73, 0x64, 0x2c,
+	0xbe, 0x84, 0xf0, 0x34, 0x4f, 0xa7, 0x86, 0xb3, 0x38, 0x18, 0x74, 0x86, 0x3b, 0x49, 0x3d, 0xfc,
+	0x0f, 0x61, 0xf2, 0xa6, 0x50, 0x1d, 0x4a, 0xab, 0xcf, 0x85, 0x77, 0xf4, 0xf6, 0x01, 0x56, 0x24,
+	0xde, 0x82, 0xe0, 0x2b, 0x9d, 0x73, 0x16, 0xb3, 0x41, 0x5b, 0x14, 0x3f, 0xf1, 0x0e, 0x84, 0xcb,
+	0x34, 0x77, 0xc4, 0x1b, 0x25, 0xe7, 0xc1, 0xab, 0xc6, 0x3e, 0xeb, 0xef, 0x00, 0x5c, 0x8a, 0x70,
+	0x17, 0x22, 0xed, 0xe4, 0x78, 0x96, 0x55, 0xe6, 0x50, 0x3b, 0xf9, 0x2e, 0xeb, 0xff, 0x6c, 0x40,
+	0x20, 0x9c, 0xbc, 0xe1, 0x6f, 0x7c, 0x56, 0x07, 0x6f, 0x94, 0xc1, 0xef, 0x5d, 0x04, 0x17, 0x4e,
+	0x5e, 0x0d, 0x8b, 0xdb, 0x10, 0x19, 0x9b, 0x5a, 0x67, 0x78, 0x50, 0x4e, 0xa9, 0x10, 0xee, 0x41,
+	0x68, 0x8a, 0x4d, 0xf9, 0x7f, 0x31, 0x1b, 0x74, 0x86, 0xbd, 0xc4, 0x97, 0x98, 0xd4, 0x25, 0x26,
+	0x9f, 0xea, 0x12, 0x85, 0x17, 0xe2, 0x53, 0x08, 0x48, 0x66, 0x3c, 0xfc, 0xab, 0xbe, 0x90, 0xe1,
+	0x7d, 0x68, 0xd3, 0xf7, 0x99, 0x1d, 0x4f, 0x54, 0x46, 0x3c, 0x8a, 0xd9, 0x20, 0x14, 0xad, 0x82,
+	0x18, 0xa9, 0x8c, 0x90, 0x43, 0x73, 0x49, 0x3a, 0x9b, 0x4d, 0x2c, 0x6f, 0x96, 0xa9, 0x6a, 0x88,
+	0x0f, 0xa0, 0x6d, 0x26, 0x67, 0x94, 0xb9, 0x9c, 0x32, 0xde, 0x8a, 0xd9, 0xa0, 0x25, 0x56, 0xc4,
+	0x3f, 0x34, 0x7f, 0x06, 0x9b, 0x1f, 0xb5, 0x9a, 0x6a, 0x32, 0xe6, 0x70, 0x49, 0xd2, 0x22, 0x42,
+	0x90, 0xab, 0xa9, 0x37, 0x1f, 0x6d, 0x88, 0x02, 0x20, 0x87, 0x48, 0x39, 0xbb, 0x70, 0xd6, 0xfb,
+	0x8f, 0x36, 0x44, 0x85, 0x31, 0x86, 0x40, 0x3b, 0x59, 0x56, 0xd8, 0x19, 0x76, 0x2f, 0x57, 0x5e,
+	0x78, 0xb5, 0x93, 0x07, 0x4d, 0x08, 0xa9, 0x18, 0xdc, 0xff, 0x00, 0x91, 0x20, 0xe3, 0x72, 0x8b,
+	0x0f, 0xbd, 0x89, 0x5d, 0x35, 0x95, 0x16, 0x7c, 0x0c, 0x5d, 0x5d, 0x2a, 0xcd, 0xf8, 0x8b, 0x51,
+	0xb2, 0x7c, 0xb4, 0x2b, 0x3a, 0x15, 0xf7, 0xde, 0x28, 0x39, 0xfc, 0xc5, 0xa0, 0x39, 0xf2, 0x3e,
+	0x1c, 0x42, 0xab, 0xbe, 0x4d, 0xe4, 0x37, 0x9d, 0x6b, 0x6f, 0xed, 0x1d, 0x7c, 0x0d, 0xff, 0x1f,
+	0x5b, 0x4d, 0xe9, 0xbc, 0x5e, 0x1e, 0x6f, 0xaf, 0xe5, 0xa8, 0x4c, 0xdb, 0x17, 0xe4, 0x5a, 0x49,
+	0x7b, 0x0c, 0x9f, 0x43, 0xfb, 0x2d, 0xd9, 0x6a, 0xa1, 0x6b, 0xbd, 0x5b, 0x2b, 0xd2, 0xab, 0x9e,
+	0x40, 0x34, 0x4a, 0xe5, 0x84, 0xf2, 0xeb, 0xf5, 0x6b, 0x01, 0x0f, 0xda, 0x9f, 0xeb, 0xcf, 0xf8,
+	0x24, 0x2a, 0x4f, 0xe9, 0xc5, 0xef, 0x01, 0x00, 0x35, 0x52, 0x76, 0xb1, 0xe7, 0x03, 0x00, 0x00,
+}
@@ -0,0 +1,170 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+const (
+	daemonPidFile = "/var/run/docker.pid"
+
+	// daemonStackDumpWait is how long to look for the dump dockerd
+	// writes after SIGUSR1.
+	daemonStackDumpWait = 10 * time.Second
+)
+
+// daemonStackDumpDirs are where dockerd writes goroutine-stacks-*.log:
+// its exec root, or its data root when it has none.
+var daemonStackDumpDirs = []string{"/var/run/docker", "/var/lib/docker"}
+
+var (
+	dumpDaemonStacks bool
+
+	daemonStacksDumped sync.Once
+)
+
+// dumpDaemonStacksOnDetection asks dockerd to dump its goroutine stacks
+// the first time a hang is detected, and records where the dump landed.
+// It needs the daemon to be local and the tool to be allowed to signal
+// it, otherwise it only logs why it couldn't.
+func dumpDaemonStacksOnDetection(client *docker.Client) {
+	if !dumpDaemonStacks {
+		return
+	}
+	daemonStacksDumped.Do(func() {
+		path, err := signalDaemonStackDump(client)
+		if err != nil {
+			logger.WithError(err).Warn("Could not dump daemon goroutine stacks")
+			return
+		}
+		results.setDaemonStackDump(path)
+		logger.WithField("path", path).Info("Daemon wrote goroutine stack dump")
+		if err := copyFile(path, runPath("daemon", filepath.Base(path))); err != nil {
+			logger.WithError(err).Warn("Could not copy daemon goroutine stack dump")
+		}
+	})
+}
+
+func signalDaemonStackDump(client *docker.Client) (string, error) {
+	if !strings.HasPrefix(client.Endpoint(), "unix://") {
+		return "", fmt.Errorf("daemon at %s is not on this host", client.Endpoint())
+	}
+	pid, err := daemonPID()
+	if err != nil {
+		return "", err
+	}
+	// Stat times have at most second resolution on some filesystems.
+	sent := time.Now().Truncate(time.Second)
+	if err := syscall.Kill(pid, syscall.SIGUSR1); err != nil {
+		return "", fmt.Errorf("signal dockerd (pid %d): %s", pid, err)
+	}
+	logger.WithField("pid", pid).Info("Sent SIGUSR1 to dockerd")
+
+	deadline := time.Now().Add(daemonStackDumpWait)
+	for time.Now().Before(deadline) {
+		if path := newestStackDump(sent); path != "" {
+			return path, nil
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	return "", fmt.Errorf("no stack dump appeared in %s within %s, check the daemon log",
+		strings.Join(daemonStackDumpDirs, " or "), daemonStackDumpWait)
+}
+
+// newestStackDump returns the newest dockerd stack dump written at or
+// after since, or "" if there is none yet.
+func newestStackDump(since time.Time) string {
+	var newest string
+	var newestT time.Time
+	for _, dir := range daemonStackDumpDirs {
+		matches, _ := filepath.Glob(filepath.Join(dir, "goroutine-stacks-*.log"))
+		for _, m := range matches {
+			fi, err := os.Stat(m)
+			if err != nil || fi.ModTime().Before(since) {
+				continue
+			}
+			if fi.ModTime().After(newestT) {
+				newest, newestT = m, fi.ModTime()
+			}
+		}
+	}
+	return newest
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// daemonPID finds dockerd from its pid file, or failing that by name
+// in /proc.
+func daemonPID() (int, error) {
+	if b, err := ioutil.ReadFile(daemonPidFile); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil {
+			return pid, nil
+		}
+	}
+	return findProcess("dockerd")
+}
+
+// findProcess returns the pid of the first process whose command name
+// is name.
+func findProcess(name string) (int, error) {
+	// The kernel truncates command names to 15 bytes.
+	comm := name
+	if len(comm) > 15 {
+		comm = comm[:15]
+	}
+	dirs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return 0, err
+	}
+	for _, d := range dirs {
+		pid, err := strconv.Atoi(d.Name())
+		if err != nil {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join("/proc", d.Name(), "comm"))
+		if err == nil && strings.TrimSpace(string(b)) == comm {
+			return pid, nil
+		}
+	}
+	return 0, fmt.Errorf("no %s process found", name)
+}
@@ -0,0 +1,149 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+)
+
+var dashboardAddr string
+
+// eventStates maps container event actions to the state they leave the
+// container in.
+var eventStates = map[string]string{
+	"create":  "created",
+	"start":   "running",
+	"restart": "running",
+	"unpause": "running",
+	"pause":   "paused",
+	"die":     "exited",
+	"destroy": "removed",
+}
+
+// serveDashboard serves a page showing the run's results as they come
+// in on addr, until the run exits.
+func serveDashboard(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(dashboardPage))
+	})
+	mux.HandleFunc("/results.json", func(w http.ResponseWriter, req *http.Request) {
+		b, err := results.liveJSON()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	})
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: mux}
+	goSafe(func() {
+		srv.Serve(l)
+	})
+	onTeardown(phaseStreams, "dashboard", func(ctx context.Context) error {
+		return srv.Shutdown(ctx)
+	})
+	logger.WithField("url", "http://"+l.Addr().String()+"/").Info("Serving dashboard")
+	return nil
+}
+
+// liveJSON returns the results of the run so far.
+func (r *runResult) liveJSON() ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return json.Marshal(r)
+}
+
+const dashboardPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>health-stats-repro</title>
+<style>
+body { font-family: monospace; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; }
+.affected, .failed { background: #fdd; }
+.unverified { background: #ffd; }
+</style>
+</head>
+<body>
+<h1>Run <span id="run"></span></h1>
+<p id="status"></p>
+<h2>Containers</h2>
+<table id="containers"></table>
+<h2>Hung calls</h2>
+<table id="hung"></table>
+<h2>Daemon pings</h2>
+<table id="pings"></table>
+<script>
+function ms(ns) { return ns < 0 ? "-" : (ns / 1e6).toFixed(1) + "ms"; }
+function pct(ds, p) {
+  if (!ds.length) return -1;
+  ds = ds.slice().sort(function(a, b) { return a - b; });
+  return ds[Math.max(1, Math.ceil(p * ds.length / 100)) - 1];
+}
+function table(id, head, rows) {
+  var html = "<tr>" + head.map(function(h) { return "<th>" + h + "</th>"; }).join("") + "</tr>";
+  rows.forEach(function(r) {
+    html += "<tr class='" + (r.cls || "") + "'>" + r.cells.map(function(c) { return "<td>" + c + "</td>"; }).join("") + "</tr>";
+  });
+  document.getElementById(id).innerHTML = html;
+}
+function refresh() {
+  fetch("results.json").then(function(resp) { return resp.json(); }).then(function(r) {
+    document.getElementById("run").textContent = r.run_id;
+    document.getElementById("status").textContent = "Started " + r.start + ", engine " + r.engine.version +
+      (r.verdict ? ", " + r.verdict : ", running") + (r.symptoms ? ", symptoms: " + r.symptoms.join(", ") : "");
+    table("containers", ["Container", "State", "Health", "Transitions", "Stats samples", "Op p50", "Op p99", "Last op", "Verdict"],
+      (r.containers || []).map(function(c) {
+        var ops = c.ops || [];
+        var ds = ops.map(function(o) { return o.duration; });
+        var last = ops.length ? ops[ops.length - 1] : null;
+        return {cls: c.verdict, cells: [c.id.substr(0, 12), c.state || "", c.health || "", c.health_transitions, c.stats_samples,
+          ms(pct(ds, 50)), ms(pct(ds, 99)), last ? last.op + " " + ms(last.duration) + (last.error ? " " + last.error : "") : "", c.verdict]};
+      }));
+    table("hung", ["Container", "Op", "Started", "Returned", "After"],
+      (r.hung_calls || []).map(function(h) {
+        return {cls: h.returned ? "" : "failed", cells: [h.container_id.substr(0, 12), h.op, h.start, h.returned, ms(h.returned_after)]};
+      }));
+    table("pings", ["Time", "Latency", "Error"],
+      (r.pings || []).slice(-20).reverse().map(function(p) {
+        return {cls: p.error ? "failed" : "", cells: [p.time, ms(p.duration), p.error || ""]};
+      }));
+  }).catch(function(err) {
+    document.getElementById("status").textContent = "Run is no longer reachable: " + err;
+  });
+}
+refresh();
+setInterval(refresh, 2000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,101 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	failFast bool
+
+	goroutinesDumped sync.Once
+
+	// eventLog is the run's event backlog, nil if the event stream
+	// could not be listened to.
+	eventLog *eventBacklog
+)
+
+// hangDetected is called when a daemon call against cont did not return
+// within its timeout. It marks the container affected and collects
+// diagnostics.
+func hangDetected(client *docker.Client, cont *docker.Container, op string, err error) {
+	hlog := logger.WithFields(logrus.Fields{
+		"container_id": cont.ID,
+		"operation":    op,
+	})
+	// Whether the daemon still answers pings tells a hung container
+	// apart from a hung daemon.
+	if ping, ok := results.lastPing(); ok {
+		hlog = hlog.WithField("last_ping", ping.Time.Format(time.RFC3339))
+		if ping.Error != "" {
+			hlog = hlog.WithField("last_ping_error", ping.Error)
+		}
+	}
+	hlog.WithError(err).Error("Hang detected")
+
+	markAffected(cont.ID)
+	results.setVerdict(cont.ID, verdictAffected)
+	notifyOnDetection(cont.ID, op, err)
+
+	dumpGoroutinesOnDetection(cont, op)
+	dumpDaemonStacksOnDetection(client)
+	collectPprofOnDetection()
+	snapshotOnDetection(client, eventLog, fmt.Sprintf("%s of container %s hung: %s", op, cont.ID, err))
+}
+
+// failFastOnHang ends the run there and then with --fail-fast if err is
+// the timeout of op, a call of the inspect or kill check against cont.
+// Hangs of the calls made in the background are only recorded.
+func failFastOnHang(cont *docker.Container, op string, err error) {
+	if failFast && classifyError(err) == errClassTimeout {
+		exit(2, fmt.Sprintf("FAIL: %s of container %s hung (fail-fast)", op, cont.ID))
+	}
+}
+
+// dumpGoroutinesOnDetection writes the tool's own stacks the first time
+// a hang is detected. They show which client call, on which connection,
+// is stuck; once the daemon is wedged, later dumps only show more of the
+// same.
+func dumpGoroutinesOnDetection(cont *docker.Container, op string) {
+	goroutinesDumped.Do(func() {
+		name := runPath(fmt.Sprintf("goroutines-%s-%s-%s.txt", time.Now().Format(time.RFC3339), op, shortID(cont.ID)))
+		if err := ioutil.WriteFile(name, goroutineStacks(), 0640); err != nil {
+			logger.WithError(err).Error("Could not write goroutine dump")
+			return
+		}
+		logger.WithField("path", name).Info("Wrote goroutine dump")
+	})
+}
+
+// goroutineStacks returns the stacks of all of the tool's goroutines.
+func goroutineStacks() []byte {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// writeDiagnoseScript writes a shell script with the commands an
+// operator should run on the host of a wedged daemon to look at the
+// affected containers. saved holds the paths of inspect documents the
+// run already saved, by container ID. It returns the script's path.
+func writeDiagnoseScript(conts []*docker.Container, saved map[string]string) (string, error) {
+	// Each of the commands may hang on the daemon as well.
+	cli := fmt.Sprintf("timeout %d docker", callTimeoutSecs)
+	since := results.Start.Unix()
+	until := time.Now().Unix()
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "#!/bin/sh\n# Diagnostics of run %s, which affected %d container(s).\nset -x\n\n", runID, len(conts))
+	fmt.Fprintf(&buf, "%s ps -a --filter label=%s=%s\n", cli, runLabel, runID)
+	for _, c := range conts {
+		fmt.Fprintf(&buf, "\n# Container %s\n", c.ID)
+		if path, ok := saved[c.ID]; ok {
+			fmt.Fprintf(&buf, "# Its inspect document after the run is in %s\n", path)
+		}
+		fmt.Fprintf(&buf, "%s inspect %s\n", cli, c.ID)
+		fmt.Fprintf(&buf, "%s top %s\n", cli, c.ID)
+		fmt.Fprintf(&buf, "%s logs --timestamps --since %d %s\n", cli, since, c.ID)
+		fmt.Fprintf(&buf, "%s events --since %d --until %d --filter container=%s\n", cli, since, until, c.ID)
+	}
+
+	name := runPath(fmt.Sprintf("diagnose-%s.sh", runID))
+	return name, ioutil.WriteFile(name, buf.Bytes(), 0755)
+}
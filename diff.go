@@ -0,0 +1,204 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// diffOps are the container operations whose latencies are compared.
+var diffOps = []string{"kill", "stop", "inspect", "remove"}
+
+// loadedRun is a finished run read back from its directory.
+type loadedRun struct {
+	Results *runResult
+	Config  runConfig
+}
+
+// loadRun reads a run given its ID, its directory or its results.json.
+func loadRun(ref string) (*loadedRun, error) {
+	dir := ref
+	if fi, err := os.Stat(ref); err != nil {
+		dir = filepath.Join(runsDir, ref)
+	} else if !fi.IsDir() {
+		dir = filepath.Dir(ref)
+	}
+
+	run := &loadedRun{Results: &runResult{}}
+	if err := readJSON(filepath.Join(dir, "results.json"), run.Results); err != nil {
+		return nil, err
+	}
+	// Runs that failed early have no configuration, compare them
+	// without.
+	if err := readJSON(filepath.Join(dir, "config.json"), &run.Config); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return run, nil
+}
+
+func readJSON(path string, v interface{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewDecoder(f).Decode(v)
+}
+
+// diffCommand compares two runs, to see what changed between them, eg.
+// across a daemon upgrade.
+func diffCommand(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s diff <run-a> <run-b>\n\nRuns are given by ID, directory or results.json.\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	a, err := loadRun(fs.Arg(0))
+	if err != nil {
+		logger.WithError(err).Fatalf("Could not load run %s", fs.Arg(0))
+	}
+	b, err := loadRun(fs.Arg(1))
+	if err != nil {
+		logger.WithError(err).Fatalf("Could not load run %s", fs.Arg(1))
+	}
+	printDiff(os.Stdout, a, b)
+}
+
+func printDiff(out io.Writer, a, b *loadedRun) {
+	ra, rb := a.Results, b.Results
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "\tA\tB\n")
+	fmt.Fprintf(tw, "run\t%s\t%s\n", ra.RunID, rb.RunID)
+	diffRow(tw, "verdict", ra.Verdict, rb.Verdict)
+	diffRow(tw, "exit code", fmt.Sprint(ra.ExitCode), fmt.Sprint(rb.ExitCode))
+	diffRow(tw, "invalid", ra.Invalid, rb.Invalid)
+
+	fmt.Fprintln(tw, "\t\t")
+	diffRow(tw, "engine", ra.Engine.Version, rb.Engine.Version)
+	diffRow(tw, "api version", ra.Engine.APIVersion, rb.Engine.APIVersion)
+	diffRow(tw, "git commit", ra.Engine.GitCommit, rb.Engine.GitCommit)
+	diffRow(tw, "kernel", ra.Engine.KernelVersion, rb.Engine.KernelVersion)
+	diffRow(tw, "storage driver", ra.Engine.StorageDriver, rb.Engine.StorageDriver)
+	diffRow(tw, "containerd", ra.Engine.ContainerdCommit, rb.Engine.ContainerdCommit)
+	diffRow(tw, "runc", ra.Engine.RuncCommit, rb.Engine.RuncCommit)
+
+	// A run that failed before writing its configuration would show
+	// every flag as changed.
+	if a.Config.Flags == nil || b.Config.Flags == nil {
+		fmt.Fprintln(tw, "\t\t")
+		fmt.Fprintln(tw, "config\t(not comparable, a run has no config.json)\t")
+	} else {
+		printConfigDiff(tw, a.Config.Flags, b.Config.Flags)
+	}
+
+	fmt.Fprintln(tw, "\t\t")
+	diffRow(tw, "affected", affectedSummary(ra), affectedSummary(rb))
+	diffRow(tw, "symptoms", strings.Join(ra.Symptoms, ", "), strings.Join(rb.Symptoms, ", "))
+	diffRow(tw, "hung calls", fmt.Sprint(len(ra.HungCalls)), fmt.Sprint(len(rb.HungCalls)))
+	diffRow(tw, "failed pings", failedPings(ra), failedPings(rb))
+	for _, op := range diffOps {
+		da, db := runDurations(ra, op), runDurations(rb, op)
+		if len(da) == 0 && len(db) == 0 {
+			continue
+		}
+		for _, p := range []int{50, 99} {
+			diffRow(tw, fmt.Sprintf("%s p%d", op, p),
+				formatDuration(percentile(da, p)),
+				formatDuration(percentile(db, p)))
+		}
+	}
+	tw.Flush()
+}
+
+// printConfigDiff writes a row for each flag whose value differs.
+func printConfigDiff(w io.Writer, a, b map[string]string) {
+	var names []string
+	for name := range a {
+		names = append(names, name)
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	header := false
+	for _, name := range names {
+		if a[name] == b[name] {
+			continue
+		}
+		if !header {
+			fmt.Fprintln(w, "\t\t")
+			header = true
+		}
+		diffRow(w, "--"+name, a[name], b[name])
+	}
+}
+
+// diffRow writes a row, marking it when the runs differ.
+func diffRow(w io.Writer, name, a, b string) {
+	mark := ""
+	if a != b {
+		mark = " *"
+	}
+	fmt.Fprintf(w, "%s%s\t%s\t%s\n", name, mark, a, b)
+}
+
+func affectedSummary(r *runResult) string {
+	n := 0
+	for _, c := range r.Containers {
+		if c.Verdict == verdictAffected {
+			n++
+		}
+	}
+	return fmt.Sprintf("%d/%d", n, len(r.Containers))
+}
+
+func failedPings(r *runResult) string {
+	n := 0
+	for _, p := range r.Pings {
+		if p.Error != "" {
+			n++
+		}
+	}
+	return fmt.Sprintf("%d/%d", n, len(r.Pings))
+}
+
+// runDurations returns the durations of op across all of r's
+// containers.
+func runDurations(r *runResult, op string) []time.Duration {
+	var ds []time.Duration
+	for _, c := range r.Containers {
+		ds = append(ds, c.durations(op)...)
+	}
+	return ds
+}
@@ -0,0 +1,297 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// printPlan writes the sequence of Docker operations a run with the
+// current configuration makes, without making any of them.
+func printPlan(out io.Writer) {
+	step := 0
+	printStep := func(format string, args ...interface{}) {
+		step++
+		fmt.Fprintf(out, "%2d. %s\n", step, fmt.Sprintf(format, args...))
+	}
+	indent := func(text string) {
+		for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+			fmt.Fprintf(out, "      %s\n", line)
+		}
+	}
+	callTimeout := time.Duration(callTimeoutSecs) * time.Second
+
+	fmt.Fprintf(out, "Dry run %s, no calls will be made to the daemon.\n\n", runID)
+	printStep("GET /version")
+	printStep("Listen for events")
+	from := "Dockerfile"
+	if contextDir != "" {
+		from = "context " + contextDir + " and Dockerfile"
+	}
+	switch {
+	case imageRef != "":
+		printStep("Pull image %s", imageRef)
+	case noBuild:
+		printStep("Inspect image %s, which must already exist", imageName)
+	case forceRebuild:
+		printStep("Build image %s with label %s=true from %s:", imageName, toolLabel, from)
+		indent(renderDockerfile())
+	default:
+		printStep("Unless image %s was built from it already, build it with label %s=true from %s:", imageName, toolLabel, from)
+		indent(renderDockerfile())
+	}
+
+	create := func(i int) {
+		control, cohortName, _ := containerRole(i - 1)
+		config, err := json.MarshalIndent(containerConfig(control), "", "  ")
+		if err != nil {
+			config = []byte(err.Error())
+		}
+		switch {
+		case cohortName != "":
+			printStep("Create container %d, of cohort %s, with config:", i, cohortName)
+		case control:
+			printStep("Create container %d, a control without healthcheck, with config:", i)
+		default:
+			printStep("Create container %d with config:", i)
+		}
+		indent(string(config))
+		if volumeOps {
+			fmt.Fprintf(out, "    with a volume of its own mounted at %s, created first (timeout %s)\n", volumeMountPath, callTimeout)
+		}
+		if hc := hostConfig(); hc != nil {
+			config, err := json.MarshalIndent(hc, "", "  ")
+			if err != nil {
+				config = []byte(err.Error())
+			}
+			fmt.Fprintln(out, "    and host config:")
+			indent(string(config))
+		}
+	}
+
+	// A shuffled run draws the same order from its seed as here.
+	rng := scheduleRand()
+	if rng != nil {
+		if replayPath != "" {
+			fmt.Fprintf(out, "    Creates, starts and checks are replayed from %s.\n", replayPath)
+		} else {
+			fmt.Fprintf(out, "    Creates, starts and checks are shuffled with seed %d.\n", seed)
+		}
+		planned := startPlan(rng)
+		waits := delays(planned)
+		for n, step := range planned {
+			if waits[n] > 0 {
+				printStep("Wait %s", waits[n])
+			}
+			if step.Step == 0 {
+				create(step.Container + 1)
+			} else {
+				printStep("Start container %d", step.Container+1)
+			}
+		}
+	} else {
+		for i := 1; i <= containerCount; i++ {
+			create(i)
+		}
+		printStep("Start container 1")
+		if scenario == scenarioDependsOnHealthy {
+			printStep("Inspect container 1 every 1s (timeout %s each) until healthy, for up to %s", callTimeout, healthyGateTimeout)
+		}
+		for i := 2; i <= containerCount; i++ {
+			if startStagger > 0 {
+				printStep("Wait %s", startStagger)
+			}
+			printStep("Start container %d", i)
+		}
+	}
+	var streamed []string
+	for i := 0; i < containerCount; i++ {
+		if _, _, stats := containerRole(i); stats {
+			streamed = append(streamed, fmt.Sprint(i+1))
+		}
+	}
+	if followLogs {
+		printStep("Follow the logs of all containers")
+	}
+	if attachFollowers {
+		printStep("Attach to all containers (timeout %s each)", callTimeout)
+	}
+	if len(streamed) != 0 {
+		if statsPollInterval > 0 {
+			printStep("Poll one-shot stats of containers %s every %s", strings.Join(streamed, ", "), statsPollInterval)
+		} else {
+			printStep("Stream stats from containers %s", strings.Join(streamed, ", "))
+		}
+		if statsStallFactor > 0 && chaosRate == 0 && !restartsContainers() {
+			fmt.Fprintf(out, "    A container whose stats go %g intervals without a sample is affected.\n", statsStallFactor)
+		}
+	}
+	if networkChurnInterval > 0 {
+		printStep("Create network health-stats-repro-%s (timeout %s)", runID, callTimeout)
+	}
+	printStep("Wait %s", runDuration)
+	if chaosRate > 0 {
+		fmt.Fprintf(out, "    %g random pause/unpause, restart, kill or rename operations a second are made while waiting (seed %d).\n", chaosRate, chaosSeed)
+	}
+	if topInterval > 0 {
+		fmt.Fprintf(out, "    The containers' processes are listed every %s, %d or more besides their own are flagged.\n", topInterval, topLeakThreshold)
+	}
+	if scenario == scenarioExecFlood {
+		fmt.Fprintf(out, "    %g execs of echo hello a second are made in each container while waiting.\n", execRate)
+	}
+	if networkChurnInterval > 0 {
+		fmt.Fprintf(out, "    Every %s, the next container is connected to the network, or disconnected from it again, while waiting.\n", networkChurnInterval)
+	}
+	if scenario == scenarioChurn {
+		fmt.Fprintf(out, "    Every %s, the next container with a healthcheck is stopped and restarted while waiting.\n", churnInterval)
+	}
+	if scenario == scenarioHTTPProbe {
+		fmt.Fprintf(out, "    The containers' listeners are probed from the host every %s, until they are checked.\n", workloadProbeInterval)
+	}
+	if scenario == scenarioExitRestart {
+		fmt.Fprintf(out, "    The containers exit every %ds and are restarted as their %s restart policy says.\n", exitAfter, restartPolicy.Name)
+	}
+	if verifyInterval > 0 {
+		fmt.Fprintf(out, "    Each container is inspected every %s (timeout %s) while waiting, a hang is alerted on right away.\n", verifyInterval, callTimeout)
+	}
+	if inspectQPS > 0 {
+		fmt.Fprintf(out, "    The containers are inspected at %g/s (timeout %s each) while waiting.\n", inspectQPS, callTimeout)
+	}
+
+	if failFast {
+		fmt.Fprintln(out, "    The run stops at the first kill or inspect that times out.")
+	}
+	if hostLoadGuarded() {
+		fmt.Fprintf(out, "    Host load is checked before the run and every %s during it (%s on breach).\n", hostLoadInterval, hostLoadAction)
+	}
+	if heartbeatInterval > 0 {
+		fmt.Fprintf(out, "    The daemon is pinged every %s (timeout %s) throughout.\n", heartbeatInterval, heartbeatTimeout)
+	}
+	if daemonProcInterval > 0 {
+		fmt.Fprintf(out, "    Local dockerd and containerd resource usage is sampled every %s.\n", daemonProcInterval)
+	}
+	if daemonRestartPoll > 0 {
+		fmt.Fprintf(out, "    The daemon's identity is checked every %s, a restart makes the run inconclusive.\n", daemonRestartPoll)
+	}
+	if daemonPprof {
+		fmt.Fprintln(out, "    dockerd pprof profiles are saved at start, on the first hang and at the end, if debug is enabled.")
+	}
+	if containerdDebugSocket != "" {
+		fmt.Fprintf(out, "    containerd pprof profiles are saved from %s at the same points.\n", containerdDebugSocket)
+	}
+	if dumpDaemonStacks {
+		fmt.Fprintln(out, "    On the first hang, dockerd is sent SIGUSR1 to dump its goroutine stacks.")
+	}
+	prepare := func(i int) {
+		if checkExecIDs {
+			printStep("Inspect container %d for its execs (timeout %s)", i, callTimeout)
+		}
+		if checkPause {
+			printStep("Pause container %d (timeout %s)", i, pauseTimeout)
+			printStep("Unpause container %d (timeout %s)", i, pauseTimeout)
+		}
+		if checkRestart {
+			printStep("Restart container %d, killing it after %ds (timeout %s)", i, restartTimeout, callTimeout+time.Duration(restartTimeout)*time.Second)
+		}
+	}
+	// Auto-removed containers have nothing left to check.
+	removed := make(map[int]bool)
+	check := func(i int, op checkOp) {
+		if removed[i] {
+			return
+		}
+		switch op.name {
+		case "top":
+			printStep("List the processes of container %d (timeout %s)", i, op.timeout)
+		case "logs":
+			printStep("Get the logs of container %d (timeout %s)", i, op.timeout)
+		case "kill":
+			switch stopMode {
+			case stopModeEscalate:
+				printStep("Stop container %d (timeout %s), if that fails kill it (timeout %s), if that fails too force remove it (timeout %s)",
+					i, escalationTimeouts[0], escalationTimeouts[1], escalationTimeouts[2])
+			case stopModeStop:
+				printStep("Stop container %d, killing it after %ds (timeout %s)", i, stopTimeout, op.timeout+time.Duration(stopTimeout)*time.Second)
+			default:
+				printStep("Kill container %d with signal %s (timeout %s)", i, killSignal, op.timeout)
+			}
+		case "wait":
+			printStep("Wait for container %d to exit (timeout %s)", i, op.timeout)
+		case "inspect":
+			printStep("Inspect container %d (timeout %s)", i, op.timeout)
+		case "remove":
+			var opts []string
+			if removeForce {
+				opts = append(opts, "forced")
+			}
+			if removeVolumes {
+				opts = append(opts, "with its volumes")
+			}
+			printStep("Remove container %d%s (timeout %s)", i, strings.Join(append([]string{""}, opts...), ", "), op.timeout)
+			if volumeOps {
+				printStep("Remove the volume of container %d (timeout %s)", i, op.timeout)
+			}
+		}
+		if op.name == "wait" || op.name == "kill" && !hasCheckOp("wait") {
+			if followLogs {
+				printStep("Wait for the log stream of container %d to end (timeout %s)", i, op.timeout)
+			}
+			if attachFollowers {
+				printStep("Wait for the attach stream of container %d to end (timeout %s)", i, op.timeout)
+			}
+		}
+		if op.name == "kill" && scenario == scenarioAutoRemove {
+			printStep("Inspect container %d until the daemon auto-removed it (timeout %s)", i, op.timeout)
+			removed[i] = true
+		}
+	}
+	if rng != nil {
+		planned := checkPlan(rng)
+		waits := delays(planned)
+		for n, step := range planned {
+			if waits[n] > 0 {
+				printStep("Wait %s", waits[n])
+			}
+			if step.Step == 0 {
+				prepare(step.Container + 1)
+			} else {
+				check(step.Container+1, checkOps[step.Step-1])
+			}
+		}
+	} else {
+		for i := 1; i <= containerCount; i++ {
+			prepare(i)
+			for _, op := range checkOps {
+				check(i, op)
+			}
+		}
+	}
+
+	if networkChurnInterval > 0 {
+		printStep("Remove network health-stats-repro-%s (timeout %s)", runID, callTimeout)
+	}
+	printStep("Tear down, in order: %s", strings.Join(teardownPhaseNames[:], ", "))
+	if removeImages && imageRef == "" && !noBuild {
+		printStep("Remove image %s if the run built it", imageName)
+	}
+	if collectDaemonLogs {
+		printStep("Save %s and the journal of unit %s since the run started", daemonConfigPath, daemonUnit)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// Error classes used to attribute failed daemon calls.
+const (
+	errClassTimeout    = "timeout"
+	errClassThrottled  = "throttled"
+	errClassNotFound   = "not-found"
+	errClassConflict   = "conflict"
+	errClassDaemon     = "daemon-error"
+	errClassClient     = "client-error"
+	errClassConnection = "connection"
+	errClassOther      = "other"
+)
+
+// callTimeoutError is returned for calls that were abandoned after
+// their timeout because the client offers no way to cancel them.
+type callTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e *callTimeoutError) Error() string {
+	return fmt.Sprintf("call did not return within %s", e.timeout)
+}
+
+// throttledError is a call that failed after the daemon throttled it,
+// either outright or by having it back off past its timeout.
+type throttledError struct {
+	err    error
+	count  int
+	waited time.Duration
+}
+
+func (e *throttledError) Error() string {
+	return fmt.Sprintf("%s (throttled %d time(s), backed off %s)", e.err, e.count, e.waited)
+}
+
+// attributeThrottling wraps err as a throttledError when rec shows the
+// call spent its time being throttled rather than waiting on the
+// daemon.
+func attributeThrottling(err error, rec *throttleRecord) error {
+	if err == nil || rec == nil {
+		return err
+	}
+	count, waited := rec.throttled()
+	if count == 0 {
+		return err
+	}
+	switch classifyError(err) {
+	case errClassTimeout, errClassThrottled:
+		return &throttledError{err: err, count: count, waited: waited}
+	}
+	return err
+}
+
+func classifyError(err error) string {
+	switch e := err.(type) {
+	case nil:
+		return ""
+	case *throttledError:
+		return errClassThrottled
+	case *callTimeoutError:
+		return errClassTimeout
+	case *docker.NoSuchContainer:
+		return errClassNotFound
+	case *docker.Error:
+		switch {
+		case isThrottleStatus(e.Status):
+			return errClassThrottled
+		case e.Status == http.StatusNotFound:
+			return errClassNotFound
+		case e.Status == http.StatusConflict:
+			return errClassConflict
+		case e.Status >= 500:
+			return errClassDaemon
+		default:
+			return errClassClient
+		}
+	}
+	switch err {
+	case context.DeadlineExceeded:
+		return errClassTimeout
+	case docker.ErrConnectionRefused:
+		return errClassConnection
+	}
+	return errClassOther
+}
@@ -0,0 +1,111 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// eventFilterSpec is the --event-filter flag, see parseEventFilter.
+var eventFilterSpec string
+
+// eventFilter picks the events written to the run's events log. Like
+// the filters of `docker events`, an event has to match one of the
+// values given for each key. The tool's own detectors still see every
+// event.
+type eventFilter map[string][]string
+
+// eventFilters is the run's parsed --event-filter.
+var eventFilters eventFilter
+
+// parseEventFilter parses a comma separated list of filters:
+//
+//	type=<type>         the event's type, eg. container
+//	event=<action>      the event's action, eg. health_status or exec_*
+//	container=<id>      the ID, or a prefix of it, or the name of a container
+//	label=<key>[=<val>] a label of the event's actor
+//	run                 only the containers of this run
+func parseEventFilter(spec string) (eventFilter, error) {
+	filter := eventFilter{}
+	for _, f := range strings.Split(spec, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if f == "run" {
+			filter["label"] = append(filter["label"], runLabel+"="+runID)
+			continue
+		}
+		parts := strings.SplitN(f, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("event filter %q isn't of the form key=value", f)
+		}
+		switch key, value := parts[0], parts[1]; key {
+		case "event":
+			if _, err := path.Match(value, ""); err != nil {
+				return nil, fmt.Errorf("event filter %q: %s", f, err)
+			}
+			fallthrough
+		case "type", "container", "label":
+			filter[key] = append(filter[key], value)
+		default:
+			return nil, fmt.Errorf("unknown event filter key %q", key)
+		}
+	}
+	return filter, nil
+}
+
+// match returns whether event passes the filter.
+func (f eventFilter) match(event *docker.APIEvents) bool {
+	for key, values := range f {
+		matched := false
+		for _, value := range values {
+			if f.matchOne(key, value, event) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func (f eventFilter) matchOne(key, value string, event *docker.APIEvents) bool {
+	switch key {
+	case "type":
+		return event.Type == value
+	case "event":
+		// Actions like "health_status: healthy" match by their name
+		// as well.
+		name := strings.SplitN(event.Action, ":", 2)[0]
+		full, _ := path.Match(value, event.Action)
+		short, _ := path.Match(value, name)
+		return full || short
+	case "container":
+		return event.Actor.ID != "" && strings.HasPrefix(event.Actor.ID, value) || event.Actor.Attributes["name"] == value
+	case "label":
+		kv := strings.SplitN(value, "=", 2)
+		label, ok := event.Actor.Attributes[kv[0]]
+		return ok && (len(kv) == 1 || label == kv[1])
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/sirupsen/logrus"
+)
+
+// eventsReconnectDelay is how long to wait before subscribing to the
+// event stream again once it dropped.
+const eventsReconnectDelay = time.Second
+
+// eventGap marks a stretch of the events log during which the event
+// stream was down. Replayed is how many of the events the daemon sent
+// in that time were recovered after reconnecting.
+type eventGap struct {
+	Gap      bool      `json:"gap"`
+	Since    time.Time `json:"since"`
+	Until    time.Time `json:"until"`
+	Replayed int       `json:"replayed"`
+}
+
+// reconnect subscribes to the event stream again after it dropped, and
+// replays the events since *last, the time of the last event seen, in
+// nanoseconds. It returns the new listener, or nil once the backlog is
+// stopped.
+func (b *eventBacklog) reconnect(client *docker.Client, last *int64) chan *docker.APIEvents {
+	since := time.Unix(0, *last)
+	if *last == 0 {
+		since = results.Start
+	}
+	for {
+		if b.isStopped() {
+			return nil
+		}
+		logger.WithField("last_event", since.Format(time.RFC3339Nano)).Warn("Event stream dropped, reconnecting")
+		if err := sleepCtx(rootCtx, eventsReconnectDelay); err != nil {
+			return nil
+		}
+		events := make(chan *docker.APIEvents, 64)
+		if err := client.AddEventListener(events); err != nil {
+			logger.WithError(err).Warn("Could not listen for events")
+			continue
+		}
+		b.mu.Lock()
+		b.listener = events
+		b.mu.Unlock()
+
+		until := time.Now()
+		replayed, err := b.replay(client, last, since, until)
+		glog := logger.WithFields(logrus.Fields{
+			"since":    since.Format(time.RFC3339Nano),
+			"until":    until.Format(time.RFC3339Nano),
+			"replayed": replayed,
+		})
+		if err != nil {
+			glog = glog.WithError(err)
+		}
+		glog.Warn("Reconnected to the event stream, events may be missing in between")
+		b.markGap(eventGap{Gap: true, Since: since, Until: until, Replayed: replayed})
+		return events
+	}
+}
+
+// replay handles the events the daemon sent between since and until,
+// after *last.
+func (b *eventBacklog) replay(client *docker.Client, last *int64, since, until time.Time) (int, error) {
+	u, err := daemonURL(client, "/events")
+	if err != nil {
+		return 0, err
+	}
+	u += fmt.Sprintf("?since=%d.%09d&until=%d.%09d", since.Unix(), since.Nanosecond(), until.Unix(), until.Nanosecond())
+	ctx, cancel := context.WithTimeout(rootCtx, time.Duration(callTimeoutSecs)*time.Second)
+	defer cancel()
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("GET /events: %s", resp.Status)
+	}
+	dec := json.NewDecoder(resp.Body)
+	n := 0
+	for {
+		var event docker.APIEvents
+		if err := dec.Decode(&event); err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+		if event.TimeNano <= *last {
+			continue
+		}
+		*last = event.TimeNano
+		b.handle(&event)
+		n++
+	}
+}
+
+// markGap writes gap to the events log.
+func (b *eventBacklog) markGap(gap eventGap) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.out == nil {
+		return
+	}
+	if err := json.NewEncoder(b.out).Encode(gap); err != nil {
+		logger.WithError(err).Warn("Could not write event gap to events log")
+	}
+}
+
+func (b *eventBacklog) isStopped() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stopped
+}
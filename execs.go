@@ -0,0 +1,65 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// checkExecIDs inspects the containers once more before they are
+// stopped, when their exec lists still say something.
+var checkExecIDs bool
+
+// expectedExecs is how many execs a container is expected to have in
+// flight at once: its healthcheck, if it has one. It is -1 when execs
+// of the run itself make that unknowable.
+func expectedExecs(control bool) int {
+	switch {
+	case scenario == scenarioExecFlood:
+		return -1
+	case control || !useHealthchecks:
+		return 0
+	}
+	return 1
+}
+
+// noteExecIDs records the length of the exec list of an inspected
+// container. Healthcheck execs that are never cleaned up make it grow
+// over the run.
+func noteExecIDs(insp *docker.Container) {
+	if insp == nil || insp.State.Status == "exited" {
+		// Exited containers drop their execs.
+		return
+	}
+	results.recordExecIDs(insp.ID, len(insp.ExecIDs), expectedExecs(results.isControl(insp.ID)))
+}
+
+// inspectExecIDs inspects cont for its exec list before it is stopped.
+func inspectExecIDs(client *docker.Client, cont *docker.Container) {
+	var insp *docker.Container
+	olog, err := timedOp(client, cont, "inspect-execs", time.Duration(callTimeoutSecs)*time.Second, func(ctx context.Context) (err error) {
+		insp, err = client.InspectContainerWithContext(cont.ID, ctx)
+		return err
+	})
+	if err != nil {
+		olog.Warn("Could not inspect container for its execs")
+		return
+	}
+	noteExecIDs(insp)
+}
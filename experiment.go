@@ -0,0 +1,180 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// experiment splits the run's containers into matched cohorts, with and
+// without a healthcheck and stats streaming, so that the contribution of
+// either to the hang can be told apart on the same daemon at the same
+// time.
+var experiment bool
+
+// cohort is a combination of the factors under test.
+type cohort struct {
+	Name        string
+	Healthcheck bool
+	Stats       bool
+}
+
+// cohorts are assigned round robin in creation order, so that each
+// cohort's containers are spread evenly over the run. The first one has
+// a healthcheck for the depends-on-healthy scenario to wait on.
+var cohorts = []cohort{
+	{Name: "healthcheck+stats", Healthcheck: true, Stats: true},
+	{Name: "healthcheck", Healthcheck: true},
+	{Name: "stats", Stats: true},
+	{Name: "baseline"},
+}
+
+// containerCohort returns the cohort of the i-th container.
+func containerCohort(i int) cohort {
+	return cohorts[i%len(cohorts)]
+}
+
+// containerRole returns whether the i-th container of the run is a
+// control without a healthcheck, its cohort in an experiment and
+// whether its stats are streamed.
+func containerRole(i int) (control bool, cohortName string, stats bool) {
+	if experiment {
+		co := containerCohort(i)
+		return !co.Healthcheck, co.Name, co.Stats
+	}
+	return i >= containerCount-controlCount(), "", streamStats
+}
+
+// factorResult compares the containers with a factor to the ones
+// without it.
+type factorResult struct {
+	Factor          string  `json:"factor"`
+	AffectedWith    int     `json:"affected_with"`
+	TotalWith       int     `json:"total_with"`
+	AffectedWithout int     `json:"affected_without"`
+	TotalWithout    int     `json:"total_without"`
+	PValue          float64 `json:"p_value"`
+}
+
+// compareCohorts compares the affected counts of the containers with
+// and without each factor with Fisher's exact test. The caller holds
+// r.mu.
+func (r *runResult) compareCohorts() []factorResult {
+	var healthcheck, stats factorResult
+	healthcheck.Factor = "healthcheck"
+	stats.Factor = "stats"
+	found := false
+	for _, c := range r.Containers {
+		if c.Cohort == "" {
+			continue
+		}
+		found = true
+		for _, co := range cohorts {
+			if co.Name != c.Cohort {
+				continue
+			}
+			affected := c.Verdict == verdictAffected
+			healthcheck.add(co.Healthcheck, affected)
+			stats.add(co.Stats, affected)
+		}
+	}
+	if !found {
+		return nil
+	}
+	results := []factorResult{healthcheck, stats}
+	for i := range results {
+		f := &results[i]
+		f.PValue = fisherExact(f.AffectedWith, f.TotalWith-f.AffectedWith, f.AffectedWithout, f.TotalWithout-f.AffectedWithout)
+	}
+	return results
+}
+
+func (f *factorResult) add(with, affected bool) {
+	n := 0
+	if affected {
+		n = 1
+	}
+	if with {
+		f.TotalWith++
+		f.AffectedWith += n
+	} else {
+		f.TotalWithout++
+		f.AffectedWithout += n
+	}
+}
+
+// printCohorts prints the affected counts per cohort and the comparison
+// of each factor. The caller holds r.mu.
+func (r *runResult) printCohorts(out io.Writer) {
+	for _, co := range cohorts {
+		affected, total := 0, 0
+		for _, c := range r.Containers {
+			if c.Cohort != co.Name {
+				continue
+			}
+			total++
+			if c.Verdict == verdictAffected {
+				affected++
+			}
+		}
+		if total != 0 {
+			fmt.Fprintf(out, "Cohort %s: %d/%d affected\n", co.Name, affected, total)
+		}
+	}
+	for _, f := range r.Experiment {
+		fmt.Fprintf(out, "With %s %d/%d affected, without %d/%d (p=%.3f)\n",
+			f.Factor, f.AffectedWith, f.TotalWith, f.AffectedWithout, f.TotalWithout, f.PValue)
+	}
+}
+
+// fisherExact returns the two-sided p-value of Fisher's exact test on
+// the 2x2 table [[a, b], [c, d]].
+func fisherExact(a, b, c, d int) float64 {
+	row1, col1, n := a+b, a+c, a+b+c+d
+	if n == 0 {
+		return 1
+	}
+	observed := hypergeometric(a, row1, col1, n)
+	p := 0.0
+	lo, hi := row1+col1-n, row1
+	if lo < 0 {
+		lo = 0
+	}
+	if col1 < hi {
+		hi = col1
+	}
+	for k := lo; k <= hi; k++ {
+		if pk := hypergeometric(k, row1, col1, n); pk <= observed*(1+1e-7) {
+			p += pk
+		}
+	}
+	return math.Min(p, 1)
+}
+
+// hypergeometric is the probability of a table with k in its top left
+// cell, given its margins.
+func hypergeometric(k, row1, col1, n int) float64 {
+	return math.Exp(logChoose(col1, k) + logChoose(n-col1, row1-k) - logChoose(n, row1))
+}
+
+func logChoose(n, k int) float64 {
+	a, _ := math.Lgamma(float64(n + 1))
+	b, _ := math.Lgamma(float64(k + 1))
+	c, _ := math.Lgamma(float64(n - k + 1))
+	return a - b - c
+}
@@ -0,0 +1,53 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// TestFisherExact checks the two-sided p-values of tables laid out as
+// experiment factors are, [[affected with, unaffected with], [affected
+// without, unaffected without]], against those of scipy's fisher_exact.
+func TestFisherExact(t *testing.T) {
+	tests := []struct {
+		name       string
+		a, b, c, d int
+		want       float64
+	}{
+		{"symmetric", 3, 1, 1, 3, 0.4857142857142857},
+		{"scipy example", 8, 2, 1, 5, 0.03496503496503497},
+		{"skewed", 1, 9, 11, 3, 0.0027594561852200836},
+		{"only with affected", 5, 0, 0, 5, 0.007936507936507936},
+		{"only without affected", 0, 5, 5, 0, 0.007936507936507936},
+		{"none affected with", 0, 10, 3, 7, 0.21052631578947367},
+		{"none affected", 0, 10, 0, 10, 1},
+		{"all affected", 10, 0, 10, 0, 1},
+		{"all affected with, none without", 10, 0, 0, 10, 1.082508822446903e-05},
+		{"no runs without", 4, 6, 0, 0, 1},
+		{"no runs", 0, 0, 0, 0, 1},
+		{"same rates", 7, 3, 7, 3, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fisherExact(tt.a, tt.b, tt.c, tt.d)
+			if math.Abs(got-tt.want) > 1e-9*math.Max(tt.want, 1e-3) {
+				t.Errorf("fisherExact(%d, %d, %d, %d) = %v, want %v", tt.a, tt.b, tt.c, tt.d, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+var (
+	followLogs      bool
+	attachFollowers bool
+)
+
+// follower is a long lived stream from the daemon about a container.
+// Streams share the daemon's hijacked connection handling with stats,
+// so they may hang under the same conditions.
+type follower struct {
+	kind string
+	done chan struct{}
+	err  error
+}
+
+var (
+	followersMu sync.Mutex
+	followers   = map[string][]*follower{}
+)
+
+// startFollower runs stream for cont in the background until it ends or
+// the run's streams are torn down.
+func startFollower(cont *docker.Container, kind string, stream func(ctx context.Context) error) {
+	ctx, cancel := context.WithCancel(rootCtx)
+	f := &follower{kind: kind, done: make(chan struct{})}
+	followersMu.Lock()
+	followers[cont.ID] = append(followers[cont.ID], f)
+	followersMu.Unlock()
+
+	goSafe(func() {
+		f.err = stream(ctx)
+		close(f.done)
+		if f.err != nil && ctx.Err() == nil {
+			logger.WithField("container_id", cont.ID).WithError(f.err).Warnf("%s stream ended with an error", f.kind)
+		}
+	})
+	onTeardown(phaseStreams, kind+"-"+shortID(cont.ID), func(context.Context) error {
+		cancel()
+		return nil
+	})
+}
+
+// startLogFollower follows the logs of cont into logs/<id>.log in the
+// run's directory.
+func startLogFollower(client *docker.Client, cont *docker.Container) {
+	startFollower(cont, "logs", func(ctx context.Context) error {
+		out, err := os.Create(runPath("logs", cont.ID+".log"))
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		return client.Logs(docker.LogsOptions{
+			Context:      ctx,
+			Container:    cont.ID,
+			OutputStream: out,
+			ErrorStream:  out,
+			Follow:       true,
+			Stdout:       true,
+			Stderr:       true,
+			Timestamps:   true,
+			RawTerminal:  withTTY,
+		})
+	})
+}
+
+// startAttachFollower attaches to the output of cont. Establishing the
+// attach is bounded by timeout, and reported as a hang like any other
+// call if it isn't.
+func startAttachFollower(client *docker.Client, cont *docker.Container, timeout time.Duration) {
+	var cw docker.CloseWaiter
+	olog, err := timedOp(client, cont, "attach-open", timeout, func(ctx context.Context) (err error) {
+		success := make(chan struct{})
+		cw, err = client.AttachToContainerNonBlocking(docker.AttachToContainerOptions{
+			Container:    cont.ID,
+			OutputStream: ioutil.Discard,
+			ErrorStream:  ioutil.Discard,
+			Success:      success,
+			Stream:       true,
+			Stdout:       true,
+			Stderr:       true,
+			RawTerminal:  withTTY,
+		})
+		if err != nil {
+			return err
+		}
+		select {
+		case <-success:
+			success <- struct{}{}
+			return nil
+		case <-ctx.Done():
+			cw.Close()
+			return ctx.Err()
+		}
+	})
+	if err != nil {
+		olog.Warn("Could not attach to container")
+		return
+	}
+	olog.Debug("Attached to container")
+
+	startFollower(cont, "attach", func(ctx context.Context) error {
+		stop := make(chan struct{})
+		defer close(stop)
+		goSafe(func() {
+			select {
+			case <-ctx.Done():
+				cw.Close()
+			case <-stop:
+			}
+		})
+		return cw.Wait()
+	})
+}
+
+// checkFollowersEnded verifies that the streams of a container that
+// exited end as well, within timeout.
+func checkFollowersEnded(client *docker.Client, cont *docker.Container, timeout time.Duration) {
+	followersMu.Lock()
+	fs := followers[cont.ID]
+	followersMu.Unlock()
+	for _, f := range fs {
+		olog, err := timedOp(client, cont, f.kind, timeout, func(context.Context) error {
+			<-f.done
+			return f.err
+		})
+		if err != nil {
+			olog.Warnf("%s stream didn't end cleanly after the container exited", f.kind)
+		} else {
+			olog.Debugf("%s stream ended", f.kind)
+		}
+	}
+}
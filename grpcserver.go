@@ -0,0 +1,166 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/jahkeup/health-stats-repro/control"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// controlServer serves the control API over gRPC, on top of the same
+// run manager as the HTTP API.
+type controlServer struct {
+	m *runManager
+}
+
+// serveGRPC serves the gRPC control API on lis. With a token, calls must
+// carry it in their authorization metadata, as HTTP requests do in their
+// Authorization header.
+func serveGRPC(lis net.Listener, m *runManager, token string) error {
+	var opts []grpc.ServerOption
+	if token != "" {
+		opts = append(opts,
+			grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+				if err := checkGRPCToken(ctx, token); err != nil {
+					return nil, err
+				}
+				return handler(ctx, req)
+			}),
+			grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+				if err := checkGRPCToken(ss.Context(), token); err != nil {
+					return err
+				}
+				return handler(srv, ss)
+			}),
+		)
+	}
+	srv := grpc.NewServer(opts...)
+	control.RegisterControlServer(srv, controlServer{m: m})
+	return srv.Serve(lis)
+}
+
+func checkGRPCToken(ctx context.Context, token string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var auth string
+	if v := md["authorization"]; len(v) != 0 {
+		auth = v[0]
+	}
+	if !validToken(auth, token) {
+		return status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	return nil
+}
+
+func (s controlServer) StartRun(ctx context.Context, req *control.StartRunRequest) (*control.Run, error) {
+	if err := checkAPIFlags(req.Flags); err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	run, err := s.m.start(req.Flags, false)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	started, _ := s.m.get(run.RunID)
+	return runProto(started), nil
+}
+
+func (s controlServer) StreamProgress(req *control.RunRequest, stream control.Control_StreamProgressServer) error {
+	if _, ok := s.m.get(req.RunId); !ok {
+		return status.Errorf(codes.NotFound, "no run %s", req.RunId)
+	}
+	err := s.m.followProgress(stream.Context(), req.RunId, func(ev progressEvent) error {
+		return stream.Send(progressProto(ev))
+	}, nil)
+	switch err {
+	case nil:
+		return nil
+	case context.Canceled:
+		return status.Error(codes.Canceled, err.Error())
+	case context.DeadlineExceeded:
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	if _, ok := status.FromError(err); !ok {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return err
+}
+
+func (s controlServer) GetResult(ctx context.Context, req *control.RunRequest) (*control.Result, error) {
+	run, ok := s.m.get(req.RunId)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no run %s", req.RunId)
+	}
+	if run.Status != runFinished {
+		return nil, status.Errorf(codes.FailedPrecondition, "run %s hasn't finished", req.RunId)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(runsDir, run.RunID, "results.json"))
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return &control.Result{Run: runProto(run), ResultsJson: b}, nil
+}
+
+func (s controlServer) Cancel(ctx context.Context, req *control.RunRequest) (*control.Run, error) {
+	if _, ok := s.m.get(req.RunId); !ok {
+		return nil, status.Errorf(codes.NotFound, "no run %s", req.RunId)
+	}
+	if err := s.m.cancel(req.RunId); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	run, _ := s.m.get(req.RunId)
+	return runProto(run), nil
+}
+
+func runProto(run managedRun) *control.Run {
+	pr := &control.Run{
+		RunId:     run.RunID,
+		Flags:     run.Flags,
+		Status:    run.Status,
+		Start:     timestampProto(run.Start),
+		Verdict:   run.Verdict,
+		Scheduled: run.Scheduled,
+	}
+	if run.End != nil {
+		pr.End = timestampProto(*run.End)
+	}
+	if run.ExitCode != nil {
+		pr.ExitCode = int32(*run.ExitCode)
+	}
+	return pr
+}
+
+func timestampProto(t time.Time) *timestamp.Timestamp {
+	return &timestamp.Timestamp{Seconds: t.Unix(), Nanos: int32(t.Nanosecond())}
+}
+
+func progressProto(ev progressEvent) *control.ProgressEvent {
+	switch {
+	case ev.Run != nil:
+		return &control.ProgressEvent{Event: &control.ProgressEvent_Run{Run: runProto(*ev.Run)}}
+	case ev.Log != nil:
+		return &control.ProgressEvent{Event: &control.ProgressEvent_Log{Log: string(ev.Log)}}
+	}
+	return &control.ProgressEvent{Event: &control.ProgressEvent_Output{Output: ev.Output}}
+}
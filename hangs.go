@@ -0,0 +1,112 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// Outcomes of a hang attributed to a container.
+const (
+	hangReturned      = "returned"
+	hangNeverReturned = "never returned"
+	hangStalled       = "stalled"
+	hangFailed        = "failed"
+)
+
+// hangAttribution is a daemon call that hung, or failed, against a
+// container: which call, its timeout and how long it took.
+type hangAttribution struct {
+	Op       string        `json:"op"`
+	Timeout  time.Duration `json:"timeout,omitempty"`
+	Duration time.Duration `json:"duration"`
+	Outcome  string        `json:"outcome"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// attributeHangs records on each container the calls that hung against
+// it, a stalled stats stream included. Affected containers without a
+// hung call get the call that failed instead. The caller holds r.mu.
+func (r *runResult) attributeHangs() {
+	for _, c := range r.Containers {
+		c.Hangs = nil
+	}
+	for _, call := range r.HungCalls {
+		c := r.container(call.ContainerID)
+		if c == nil {
+			continue
+		}
+		outcome := hangNeverReturned
+		if call.Returned {
+			outcome = hangReturned
+		}
+		c.Hangs = append(c.Hangs, hangAttribution{
+			Op:       call.Op,
+			Timeout:  call.Timeout,
+			Duration: call.ReturnedAfter,
+			Outcome:  outcome,
+			Error:    call.Error,
+		})
+	}
+	for _, c := range r.Containers {
+		if quiet, stalled := c.statsStalled(); stalled {
+			c.Hangs = append(c.Hangs, hangAttribution{
+				Op:       "stats",
+				Timeout:  statsStallAfter,
+				Duration: quiet,
+				Outcome:  hangStalled,
+			})
+		}
+		if len(c.Hangs) != 0 || c.Verdict != verdictAffected {
+			continue
+		}
+		for i := len(c.Ops) - 1; i >= 0; i-- {
+			if op := c.Ops[i]; op.Error != "" {
+				c.Hangs = append(c.Hangs, hangAttribution{
+					Op:       op.Op,
+					Duration: op.Duration,
+					Outcome:  hangFailed,
+					Error:    op.Error,
+				})
+				break
+			}
+		}
+	}
+}
+
+// printHangs writes which calls hung against which container, with
+// their timeouts and how long they took.
+func (r *runResult) printHangs(out io.Writer) {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	header := false
+	for _, c := range r.Containers {
+		for _, h := range c.Hangs {
+			if !header {
+				fmt.Fprintln(tw, "CONTAINER\tCALL\tTIMEOUT\tDURATION\tOUTCOME")
+				header = true
+			}
+			timeout := "-"
+			if h.Timeout != 0 {
+				timeout = formatDuration(h.Timeout)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", shortID(c.ID), h.Op, timeout, formatDuration(h.Duration), h.Outcome)
+		}
+	}
+	tw.Flush()
+}
@@ -0,0 +1,123 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// What the test image's healthcheck does. The daemon takes different
+// paths for healthchecks that fail, time out or aren't run through a
+// shell, so the hang may only show with some of them.
+const (
+	healthcheckSucceed = "succeed"
+	healthcheckFail    = "fail"
+	healthcheckFlap    = "flap"
+	healthcheckSlow    = "slow"
+	healthcheckExec    = "exec"
+	// healthcheckMountIO writes to the containers' first mount and
+	// syncs it, so that slow IO on the mount slows the healthcheck.
+	healthcheckMountIO = "mount-io"
+)
+
+var (
+	controlFraction        float64
+	healthcheckAtCreate    bool
+	healthcheckBehavior    string
+	healthcheckInterval    time.Duration
+	healthcheckTimeout     time.Duration
+	healthcheckRetries     uint
+	healthcheckStartPeriod time.Duration
+)
+
+func validHealthcheckBehavior(name string) bool {
+	switch name {
+	case healthcheckSucceed, healthcheckFail, healthcheckFlap, healthcheckSlow, healthcheckExec, healthcheckMountIO:
+		return true
+	}
+	return false
+}
+
+// healthcheckTest returns the healthcheck command for the behavior, in
+// the form of HealthConfig.Test.
+func healthcheckTest() []string {
+	switch healthcheckBehavior {
+	case healthcheckFail:
+		return []string{"CMD-SHELL", "echo hello; exit 1"}
+	case healthcheckFlap:
+		return []string{"CMD-SHELL", "if [ -e /tmp/healthy ]; then rm /tmp/healthy; exit 1; fi; touch /tmp/healthy"}
+	case healthcheckSlow:
+		return []string{"CMD-SHELL", fmt.Sprintf("sleep %d", int(2*healthcheckTimeout/time.Second)+1)}
+	case healthcheckExec:
+		return []string{"CMD", "echo", "hello"}
+	case healthcheckMountIO:
+		return []string{"CMD-SHELL", fmt.Sprintf("dd if=/dev/zero of=%s bs=64k count=16 conv=fsync 2>/dev/null", path.Join(mountPath(), ".health"))}
+	}
+	return []string{"CMD-SHELL", "echo hello"}
+}
+
+// healthConfig returns the healthcheck set on the containers' config
+// with --healthcheck-at-create, the way orchestrators like ECS set
+// healthchecks per container rather than in the image.
+func healthConfig() *docker.HealthConfig {
+	return &docker.HealthConfig{
+		Test:        healthcheckTest(),
+		Interval:    healthcheckInterval,
+		Timeout:     healthcheckTimeout,
+		StartPeriod: healthcheckStartPeriod,
+		Retries:     int(healthcheckRetries),
+	}
+}
+
+// controlCount is the number of the run's containers created with
+// their healthcheck disabled, as controls for the ones that have one.
+func controlCount() int {
+	return int(controlFraction*float64(containerCount) + 0.5)
+}
+
+// disabledHealthConfig disables the image's healthcheck, the way
+// --health-cmd none does.
+func disabledHealthConfig() *docker.HealthConfig {
+	return &docker.HealthConfig{Test: []string{"NONE"}}
+}
+
+// healthcheckInstruction renders the HEALTHCHECK instruction of the
+// generated Dockerfile, without the keyword itself.
+func healthcheckInstruction() string {
+	test := healthcheckTest()
+	cmd := test[1]
+	if test[0] == "CMD" {
+		args, _ := json.Marshal(test[1:])
+		cmd = string(args)
+	}
+	return healthcheckOptions() + " CMD " + cmd
+}
+
+// healthcheckOptions renders the HEALTHCHECK options of the generated
+// Dockerfile. The start period is left out unless it is set, as daemons
+// before 17.05 don't know it.
+func healthcheckOptions() string {
+	opts := fmt.Sprintf("--interval=%s --timeout=%s --retries=%d", healthcheckInterval, healthcheckTimeout, healthcheckRetries)
+	if healthcheckStartPeriod > 0 {
+		opts += fmt.Sprintf(" --start-period=%s", healthcheckStartPeriod)
+	}
+	return opts
+}
@@ -0,0 +1,60 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+var (
+	heartbeatInterval time.Duration
+	heartbeatTimeout  time.Duration
+)
+
+// pingResult is one heartbeat ping of the daemon.
+type pingResult struct {
+	Time     time.Time     `json:"time"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// heartbeat pings the daemon every interval until ctx is done. A daemon
+// that answers pings while a container's calls hang is still serving
+// its API, one that doesn't is wedged as a whole.
+func heartbeat(ctx context.Context, client *docker.Client, interval, timeout time.Duration) {
+	for sleepCtx(ctx, interval) == nil {
+		pctx, cancel := context.WithTimeout(ctx, timeout)
+		start := time.Now()
+		err := client.PingWithContext(pctx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		ping := pingResult{Time: start, Duration: time.Since(start)}
+		if err != nil {
+			if pctx.Err() == context.DeadlineExceeded {
+				err = &callTimeoutError{timeout: timeout}
+			}
+			ping.Error = err.Error()
+			logger.WithError(err).WithField("duration", ping.Duration).Warn("Daemon ping failed")
+		}
+		results.addPing(ping)
+	}
+}
@@ -0,0 +1,396 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	// The history is kept in SQLite.
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// historyName is the SQLite database in runsDir every run records its
+// outcome in. Concurrent runs wait up to historyBusyTimeout for each
+// other's writes.
+const (
+	historyName        = "history.db"
+	historyBusyTimeout = 30 * time.Second
+)
+
+// historySchema is the history's table, a row per run. The symptoms and
+// the configuration are kept as JSON.
+const historySchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	run_id         TEXT PRIMARY KEY,
+	start_time     TIMESTAMP NOT NULL,
+	end_time       TIMESTAMP NOT NULL,
+	engine         TEXT NOT NULL,
+	git_commit     TEXT NOT NULL,
+	go_version     TEXT NOT NULL,
+	kernel_version TEXT NOT NULL,
+	build_time     TEXT NOT NULL,
+	outcome        TEXT NOT NULL,
+	verdict        TEXT NOT NULL,
+	exit_code      INTEGER NOT NULL,
+	containers     INTEGER NOT NULL,
+	affected       INTEGER NOT NULL,
+	symptoms       TEXT NOT NULL,
+	config         TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS runs_start_time ON runs (start_time);
+`
+
+// historyColumns are the columns of the runs table, in the order
+// historyEntry's fields are scanned from them.
+const historyColumns = "run_id, start_time, end_time, engine, git_commit, go_version, kernel_version, build_time, " +
+	"outcome, verdict, exit_code, containers, affected, symptoms, config"
+
+// Run outcomes, by exit code.
+const (
+	outcomePass         = "PASS"
+	outcomeFail         = "FAIL"
+	outcomeInconclusive = "INCONCLUSIVE"
+	outcomeError        = "ERROR"
+	outcomeInterrupted  = "INTERRUPTED"
+)
+
+// historyEntry is a run's row in the history. Besides the engine's
+// version it records the details that tell apart builds of the same
+// version.
+type historyEntry struct {
+	RunID         string            `json:"run_id"`
+	Start         time.Time         `json:"start"`
+	End           time.Time         `json:"end"`
+	Engine        string            `json:"engine"`
+	GitCommit     string            `json:"git_commit,omitempty"`
+	GoVersion     string            `json:"go_version,omitempty"`
+	KernelVersion string            `json:"kernel_version,omitempty"`
+	BuildTime     string            `json:"build_time,omitempty"`
+	Outcome       string            `json:"outcome"`
+	Verdict       string            `json:"verdict"`
+	ExitCode      int               `json:"exit_code"`
+	Containers    int               `json:"containers"`
+	Affected      int               `json:"affected"`
+	Symptoms      []string          `json:"symptoms,omitempty"`
+	Config        map[string]string `json:"config"`
+}
+
+func outcome(code int) string {
+	switch code {
+	case 0:
+		return outcomePass
+	case 1:
+		return outcomeError
+	case 2:
+		return outcomeFail
+	case 3:
+		return outcomeInconclusive
+	}
+	return outcomeInterrupted
+}
+
+func historyPath() string {
+	return filepath.Join(runsDir, historyName)
+}
+
+// appendHistory adds the finished run r to the history.
+func appendHistory(r *runResult) error {
+	r.mu.Lock()
+	entry := historyEntry{
+		RunID:         r.RunID,
+		Start:         r.Start,
+		End:           r.End,
+		Engine:        r.Engine.Version,
+		GitCommit:     r.Engine.GitCommit,
+		GoVersion:     r.Engine.GoVersion,
+		KernelVersion: r.Engine.KernelVersion,
+		BuildTime:     r.Engine.BuildTime,
+		Outcome:       outcome(r.ExitCode),
+		Verdict:       r.Verdict,
+		ExitCode:      r.ExitCode,
+		Containers:    len(r.Containers),
+		Symptoms:      r.Symptoms,
+		Config:        flagValues(),
+	}
+	for _, c := range r.Containers {
+		if c.Verdict == verdictAffected {
+			entry.Affected++
+		}
+	}
+	r.mu.Unlock()
+
+	symptoms, err := json.Marshal(entry.Symptoms)
+	if err != nil {
+		return err
+	}
+	config, err := json.Marshal(entry.Config)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(runsDir, 0755); err != nil {
+		return err
+	}
+	db, err := openHistory(historyPath())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec("INSERT INTO runs ("+historyColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		entry.RunID, entry.Start.UTC(), entry.End.UTC(),
+		entry.Engine, entry.GitCommit, entry.GoVersion, entry.KernelVersion, entry.BuildTime,
+		entry.Outcome, entry.Verdict, entry.ExitCode, entry.Containers, entry.Affected,
+		string(symptoms), string(config))
+	return err
+}
+
+// openHistory opens the history at path, creating it if need be.
+func openHistory(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("%s?_busy_timeout=%d", path, historyBusyTimeout/time.Millisecond))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(historySchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// readHistory returns the history's entries that started after since,
+// oldest first.
+func readHistory(path string, since time.Time) ([]historyEntry, error) {
+	// Reading a history that isn't there shouldn't leave an empty one
+	// behind.
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := openHistory(path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT "+historyColumns+" FROM runs WHERE start_time >= ? ORDER BY start_time", since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []historyEntry
+	for rows.Next() {
+		var e historyEntry
+		var symptoms, config string
+		err := rows.Scan(&e.RunID, &e.Start, &e.End,
+			&e.Engine, &e.GitCommit, &e.GoVersion, &e.KernelVersion, &e.BuildTime,
+			&e.Outcome, &e.Verdict, &e.ExitCode, &e.Containers, &e.Affected,
+			&symptoms, &config)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(symptoms), &e.Symptoms); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(config), &e.Config); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func historyFlags(name string) (*flag.FlagSet, *string, *time.Duration) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	path := fs.String("file", historyPath(), "History file to read")
+	since := fs.Duration("since", 0, "Only include runs started this long ago or later (0 includes all)")
+	return fs, path, since
+}
+
+func sinceTime(d time.Duration) time.Time {
+	if d == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(-d)
+}
+
+// historyCommand lists the most recent runs.
+func historyCommand(args []string) {
+	fs, path, since := historyFlags("history")
+	limit := fs.Int("n", 20, "Number of runs to list (0 lists all)")
+	fs.Parse(args)
+
+	entries, err := readHistory(*path, sinceTime(*since))
+	if err != nil {
+		logger.WithError(err).Fatal("Could not read history")
+	}
+	if *limit > 0 && len(entries) > *limit {
+		entries = entries[len(entries)-*limit:]
+	}
+	printHistory(os.Stdout, entries)
+}
+
+func printHistory(out io.Writer, entries []historyEntry) {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RUN\tSTART\tDURATION\tENGINE\tOUTCOME\tAFFECTED")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d/%d\n",
+			e.RunID,
+			e.Start.Format(time.RFC3339),
+			formatDuration(e.End.Sub(e.Start)),
+			e.Engine,
+			e.Outcome,
+			e.Affected, e.Containers,
+		)
+	}
+	tw.Flush()
+}
+
+// statsCommand prints the repro rate of the runs in the history, grouped
+// by day, engine version, engine commit or configuration.
+func statsCommand(args []string) {
+	fs, path, since := historyFlags("stats")
+	by := fs.String("by", "day", "Group runs by day, engine, git-commit, or a comma separated list of flag names, e.g. healthchecks,scenario")
+	fs.Parse(args)
+
+	entries, err := readHistory(*path, sinceTime(*since))
+	if err != nil {
+		logger.WithError(err).Fatal("Could not read history")
+	}
+	printHistoryStats(os.Stdout, entries, historyGroupKey(*by))
+}
+
+// historyGroupKey returns the function that names the group of an entry
+// for the -by value by.
+func historyGroupKey(by string) func(historyEntry) string {
+	switch by {
+	case "day":
+		return func(e historyEntry) string { return e.Start.Format("2006-01-02") }
+	case "engine":
+		return func(e historyEntry) string { return e.Engine }
+	case "git-commit":
+		return func(e historyEntry) string {
+			if e.GitCommit == "" {
+				return "-"
+			}
+			return e.GitCommit
+		}
+	}
+	names := strings.Split(by, ",")
+	return func(e historyEntry) string {
+		parts := make([]string, len(names))
+		for i, name := range names {
+			parts[i] = name + "=" + e.Config[name]
+		}
+		return strings.Join(parts, " ")
+	}
+}
+
+type historyStats struct {
+	runs, pass, fail, inconclusive, other int
+}
+
+func (s *historyStats) add(outcome string) {
+	s.runs++
+	switch outcome {
+	case outcomePass:
+		s.pass++
+	case outcomeFail:
+		s.fail++
+	case outcomeInconclusive:
+		s.inconclusive++
+	default:
+		s.other++
+	}
+}
+
+// reproRate is the share of conclusive runs that reproduced the hang.
+func (s historyStats) reproRate() string {
+	conclusive := s.pass + s.fail
+	if conclusive == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(s.fail)/float64(conclusive))
+}
+
+// reproInterval is the Wilson score interval of the repro probability
+// of a conclusive run at the confidence level conf.
+func (s historyStats) reproInterval(conf float64) (lo, hi float64) {
+	n := float64(s.pass + s.fail)
+	if n == 0 {
+		return 0, 1
+	}
+	z := normalQuantile(0.5 + conf/2)
+	p := float64(s.fail) / n
+	center := (p + z*z/(2*n)) / (1 + z*z/n)
+	margin := z / (1 + z*z/n) * math.Sqrt(p*(1-p)/n+z*z/(4*n*n))
+	return math.Max(0, center-margin), math.Min(1, center+margin)
+}
+
+// formatInterval formats the repro interval of s at the confidence
+// level conf.
+func (s historyStats) formatInterval(conf float64) string {
+	if s.pass+s.fail == 0 {
+		return "-"
+	}
+	lo, hi := s.reproInterval(conf)
+	return fmt.Sprintf("%.1f%%-%.1f%%", 100*lo, 100*hi)
+}
+
+// normalQuantile returns the z for which the standard normal CDF is p,
+// found by bisection.
+func normalQuantile(p float64) float64 {
+	lo, hi := -10.0, 10.0
+	for i := 0; i < 100; i++ {
+		mid := (lo + hi) / 2
+		if 0.5*(1+math.Erf(mid/math.Sqrt2)) < p {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2
+}
+
+func printHistoryStats(out io.Writer, entries []historyEntry, key func(historyEntry) string) {
+	var order []string
+	groups := map[string]*historyStats{}
+	for _, e := range entries {
+		k := key(e)
+		g, ok := groups[k]
+		if !ok {
+			order = append(order, k)
+			g = &historyStats{}
+			groups[k] = g
+		}
+		g.add(e.Outcome)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "GROUP\tRUNS\tPASS\tFAIL\tINCONCLUSIVE\tERROR/INTERRUPTED\tREPRO RATE\t95% CI")
+	for _, k := range order {
+		g := groups[k]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%s\t%s\n", k, g.runs, g.pass, g.fail, g.inconclusive, g.other, g.reproRate(), g.formatInterval(0.95))
+	}
+	tw.Flush()
+}
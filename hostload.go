@@ -0,0 +1,136 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+)
+
+// What to do when the host is loaded past the thresholds.
+const (
+	hostLoadAnnotate = "annotate"
+	hostLoadAbort    = "abort"
+)
+
+var (
+	maxHostLoad      float64
+	minHostMemory    int64
+	hostLoadAction   string
+	hostLoadInterval time.Duration
+)
+
+// hostLoad is a reading of the host's load, with the thresholds it
+// crossed if any.
+type hostLoad struct {
+	Time         time.Time `json:"time"`
+	Load1        float64   `json:"load1"`
+	MemAvailable int64     `json:"mem_available"`
+	Breach       string    `json:"breach,omitempty"`
+}
+
+func hostLoadGuarded() bool {
+	return maxHostLoad > 0 || minHostMemory > 0
+}
+
+// readHostLoad reads the host's load and checks it against the
+// thresholds. The load threshold is per CPU.
+func readHostLoad() (hostLoad, error) {
+	l := hostLoad{Time: time.Now()}
+	b, err := ioutil.ReadFile("/proc/loadavg")
+	if err != nil {
+		return l, err
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return l, fmt.Errorf("empty /proc/loadavg")
+	}
+	l.Load1, err = strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return l, err
+	}
+	l.MemAvailable, err = memAvailable()
+	if err != nil {
+		return l, err
+	}
+
+	var breaches []string
+	if maxHostLoad > 0 && l.Load1/float64(runtime.NumCPU()) > maxHostLoad {
+		breaches = append(breaches, fmt.Sprintf("load %.2f over %.2f per CPU on %d CPUs", l.Load1, maxHostLoad, runtime.NumCPU()))
+	}
+	if minHostMemory > 0 && l.MemAvailable < minHostMemory {
+		breaches = append(breaches, fmt.Sprintf("%s memory available, under %s",
+			units.BytesSize(float64(l.MemAvailable)), units.BytesSize(float64(minHostMemory))))
+	}
+	l.Breach = strings.Join(breaches, ", ")
+	return l, nil
+}
+
+func memAvailable() (int64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			return kb << 10, err
+		}
+	}
+	if err := s.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no MemAvailable in /proc/meminfo")
+}
+
+// checkHostLoad reads the host's load and handles a breach by the
+// configured action, either annotating the results or ending the run
+// as inconclusive.
+func checkHostLoad(when string) {
+	l, err := readHostLoad()
+	if err != nil {
+		logger.WithError(err).Warn("Could not read host load")
+		return
+	}
+	results.addHostLoad(l)
+	if l.Breach == "" {
+		return
+	}
+	if hostLoadAction == hostLoadAbort {
+		exit(3, fmt.Sprintf("INCONCLUSIVE: host overloaded %s: %s", when, l.Breach))
+	}
+	logger.WithField("breach", l.Breach).Warnf("Host overloaded %s, results may be polluted", when)
+}
+
+// guardHostLoad checks the host's load every interval until ctx is
+// done.
+func guardHostLoad(ctx context.Context, interval time.Duration) {
+	for sleepCtx(ctx, interval) == nil {
+		checkHostLoad("during the run")
+	}
+}
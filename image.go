@@ -0,0 +1,150 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/pkg/errors"
+)
+
+// buildCommand builds the test image once so that parallel invocations
+// can share it with --no-build. It takes the same flags as a run, of
+// which only the image related ones matter, and prints the image ID.
+func buildCommand(args []string) {
+	registerFlags()
+	flag.CommandLine.Parse(args)
+	if err := selectImage(); err != nil {
+		logger.WithError(err).Fatal("Could not select image")
+	}
+
+	cl, err := newClient()
+	if err != nil {
+		logger.WithError(err).Fatal("Could not create client")
+	}
+	if err := prepareImage(cl); err != nil {
+		logger.WithError(err).Fatal("Could not build image")
+	}
+	fmt.Println(results.imageID())
+}
+
+// prepareImage makes sure the selected test image is present, by
+// pulling, checking for or building it.
+func prepareImage(client *docker.Client) error {
+	switch {
+	case imageRef != "":
+		return pullImage(client, imageRef)
+	case noBuild:
+		return requireImage(client, imageName)
+	default:
+		return buildImage(client, imageName)
+	}
+}
+
+// buildImage builds the test image under name, unless an image built
+// from the same Dockerfile already exists and --force-rebuild isn't set.
+// Builds of the same name are serialized across processes, so a process
+// that had to wait for another one's build reuses its image.
+func buildImage(client *docker.Client, name string) error {
+	unlock, waited, err := lockBuild(rootCtx, name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if !forceRebuild {
+		img, err := client.InspectImage(name)
+		if err == nil && img.Config != nil && img.Config.Labels[dockerfileLabel] == dockerfileDigest() {
+			if waited {
+				logger.WithField("image", name).Info("Reusing image built by another process")
+			} else {
+				logger.WithField("image", name).Info("Image is up to date, skipping build")
+			}
+			results.setImage(name, img.ID)
+			return nil
+		}
+	}
+	builder := builderLegacy
+	if useBuildKit {
+		builder, err = buildWithFallback(client, name)
+	} else {
+		err = client.BuildImage(buildImageOptions(name))
+	}
+	if err != nil {
+		return err
+	}
+	results.setBuilder(builder)
+	if err := requireImage(client, name); err != nil {
+		return err
+	}
+	registerImageTeardown(client, name)
+	return nil
+}
+
+// registerImageTeardown removes the image the run built under name once
+// its containers are gone, if --remove-images is set. Affected
+// containers left in place still use it, so the daemon refuses to
+// remove it then and the step is logged as failed.
+func registerImageTeardown(client *docker.Client, name string) {
+	onTeardown(phaseImages, "remove image "+name, func(ctx context.Context) error {
+		if !teardownContainers(removeImages) {
+			return nil
+		}
+		err := client.RemoveImageExtended(name, docker.RemoveImageOptions{Context: ctx})
+		if err == docker.ErrNoSuchImage {
+			return nil
+		}
+		return err
+	})
+}
+
+// requireImage records the ID of the image tagged name in the results,
+// failing if there is no such image.
+func requireImage(client *docker.Client, name string) error {
+	img, err := client.InspectImage(name)
+	if err != nil {
+		return errors.Wrapf(err, "could not inspect image %s", name)
+	}
+	logger.WithField("image", name).WithField("image_id", img.ID).Debug("Using image")
+	results.setImage(name, img.ID)
+	return nil
+}
+
+// hashContext writes the paths and contents of the files in the build
+// context dir to h, in a stable order. Files that can't be read are
+// left out, the build reports them.
+func hashContext(h io.Writer, dir string) {
+	filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil
+		}
+		defer f.Close()
+		rel, _ := filepath.Rel(dir, path)
+		fmt.Fprintf(h, "%s\x00%d\x00", filepath.ToSlash(rel), fi.Size())
+		io.Copy(h, f)
+		return nil
+	})
+}
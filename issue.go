@@ -0,0 +1,99 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+var issueReport bool
+
+// writeIssueReport renders the finished run as the body of a moby/moby
+// or aws/amazon-ecs-agent issue, into the run's issue.md.
+func writeIssueReport(r *runResult) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var b bytes.Buffer
+	e := r.Engine
+	fmt.Fprintf(&b, "**Description**\n\n")
+	fmt.Fprintf(&b, "API calls against containers with healthchecks hang, reproduced with [health-stats-repro](https://github.com/jahkeup/health-stats-repro).\n\n")
+	fmt.Fprintf(&b, "Verdict: `%s`\n\n", r.Verdict)
+	if len(r.Symptoms) != 0 {
+		fmt.Fprintf(&b, "Symptoms: %s\n\n", "`"+strings.Join(r.Symptoms, "`, `")+"`")
+	}
+
+	fmt.Fprintf(&b, "**Steps to reproduce the issue:**\n\n")
+	fmt.Fprintf(&b, "```\n%s\n```\n\n", strings.Join(append([]string{os.Args[0]}, changedFlags()...), " "))
+
+	fmt.Fprintf(&b, "**Timeline:**\n\n")
+	fmt.Fprintf(&b, "| Time | Event |\n|---|---|\n")
+	fmt.Fprintf(&b, "| %s | run %s started |\n", r.Start.Format(time.RFC3339), r.RunID)
+	for _, call := range r.HungCalls {
+		fmt.Fprintf(&b, "| %s | %s of `%s` hung (timeout %s) |\n", call.Start.Format(time.RFC3339), call.Op, shortID(call.ContainerID), call.Timeout)
+	}
+	for _, restart := range r.DaemonRestarts {
+		fmt.Fprintf(&b, "| %s | dockerd restarted |\n", restart.Time.Format(time.RFC3339))
+	}
+	fmt.Fprintf(&b, "| %s | run ended |\n\n", r.End.Format(time.RFC3339))
+
+	var affected []string
+	for _, c := range r.Containers {
+		if c.Verdict == verdictAffected {
+			affected = append(affected, c.ID)
+		}
+	}
+	if len(affected) != 0 {
+		fmt.Fprintf(&b, "**Affected containers:**\n\n```\n")
+		for _, id := range affected {
+			fmt.Fprintf(&b, "docker inspect %s\n", id)
+		}
+		fmt.Fprintf(&b, "```\n\n")
+	}
+
+	fmt.Fprintf(&b, "**Output of `docker version`:**\n\n```\n")
+	fmt.Fprintf(&b, "Version:      %s\nAPI version:  %s\nGo version:   %s\nGit commit:   %s\nBuilt:        %s\nKernel:       %s\n",
+		e.Version, e.APIVersion, e.GoVersion, e.GitCommit, e.BuildTime, e.KernelVersion)
+	fmt.Fprintf(&b, "```\n\n")
+	fmt.Fprintf(&b, "**Additional environment details:**\n\n")
+	fmt.Fprintf(&b, "- Operating system: %s\n- Storage driver: %s\n- Cgroup driver: %s\n- containerd: %s\n- runc: %s\n",
+		e.OperatingSystem, e.StorageDriver, e.CgroupDriver, e.ContainerdCommit, e.RuncCommit)
+	if r.VersionStatus != "" {
+		fmt.Fprintf(&b, "- Engine version status: %s\n", r.VersionStatus)
+	}
+	fmt.Fprintf(&b, "\nArtifacts of the run are in `%s`.\n", runDir())
+
+	name := runPath("issue.md")
+	return name, ioutil.WriteFile(name, b.Bytes(), 0644)
+}
+
+// changedFlags returns the flags set to other than their defaults, as
+// command line arguments.
+func changedFlags() []string {
+	var args []string
+	flag.VisitAll(func(f *flag.Flag) {
+		if v := f.Value.String(); v != f.DefValue {
+			args = append(args, fmt.Sprintf("--%s=%s", f.Name, v))
+		}
+	})
+	return args
+}
@@ -0,0 +1,99 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// runsDir holds a directory per run, named by run ID, laid out as:
+//
+//	config.json       the flags the run was started with
+//	results.json      the run's results
+//	stats.ndjson      every stats sample, with --stream-stats
+//	events.ndjson     every daemon event, and gaps in the event stream
+//	inspect/<id>.json inspect documents of the run's containers
+//	daemon/           daemon configuration, journal, profiles and stacks
+//	snapshot-*.json   system snapshots taken on detection
+//	goroutines-*.txt  the tool's own stacks on the first hang
+//	diagnose-<id>.sh  commands to look at the affected containers
+//	issue.md          the run as an issue body, with --issue-report
+const runsDir = "runs"
+
+func runDir() string {
+	return filepath.Join(runsDir, runID)
+}
+
+// runPath returns the path of elem in the run's directory, creating
+// the directories leading to it. A failure to create them surfaces when
+// the file is created.
+func runPath(elem ...string) string {
+	path := filepath.Join(append([]string{runDir()}, elem...)...)
+	os.MkdirAll(filepath.Dir(path), 0755)
+	return path
+}
+
+// flagValues returns the value of every flag, set or defaulted.
+func flagValues() map[string]string {
+	values := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		values[f.Name] = f.Value.String()
+	})
+	return values
+}
+
+// runConfig is what a run was started with.
+type runConfig struct {
+	RunID string            `json:"run_id"`
+	Args  []string          `json:"args"`
+	Flags map[string]string `json:"flags"`
+}
+
+// writeRunConfig writes the run's configuration to its config.json.
+func writeRunConfig() error {
+	config := runConfig{
+		RunID: runID,
+		Args:  os.Args[1:],
+		Flags: flagValues(),
+	}
+
+	f, err := os.Create(runPath("config.json"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(config)
+}
+
+// writeInspect saves an inspect document into the run's inspect
+// directory.
+func writeInspect(cont *docker.Container) error {
+	f, err := os.Create(runPath("inspect", cont.ID+".json"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(cont)
+}
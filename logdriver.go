@@ -0,0 +1,80 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	// logDriver is the log driver of the containers, the daemon's
+	// default if empty. The daemon's log copier runs alongside each
+	// container, and some drivers have been implicated in hangs.
+	logDriver string
+	// logOptsSpec holds the log driver's options, comma separated
+	// key=value pairs.
+	logOptsSpec string
+	logOpts     map[string]string
+)
+
+// readableLogDrivers are the log drivers the daemon can read logs back
+// from, which following the logs needs.
+var readableLogDrivers = []string{"json-file", "journald", "local"}
+
+// parseLogOpts parses log driver options, eg. max-size=1m,max-file=2.
+func parseLogOpts(spec string) (map[string]string, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	opts := make(map[string]string)
+	for _, kv := range strings.Split(spec, ",") {
+		i := strings.Index(kv, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("log option %q isn't key=value", kv)
+		}
+		opts[strings.TrimSpace(kv[:i])] = kv[i+1:]
+	}
+	return opts, nil
+}
+
+// checkLogDriver checks the daemon has the log driver, if it said which
+// ones it has.
+func checkLogDriver(available []string) error {
+	if logDriver == "" || len(available) == 0 {
+		return nil
+	}
+	for _, d := range available {
+		if d == logDriver {
+			return nil
+		}
+	}
+	return fmt.Errorf("log driver %q isn't available on this daemon, it has %s", logDriver, strings.Join(available, ", "))
+}
+
+// logsReadable reports whether the daemon can read back the logs of the
+// containers.
+func logsReadable() bool {
+	if logDriver == "" {
+		return true
+	}
+	for _, d := range readableLogDrivers {
+		if d == logDriver {
+			return true
+		}
+	}
+	return false
+}
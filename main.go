@@ -27,76 +27,456 @@ import (
 	"archive/tar"
 	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/docker/go-units"
 	docker "github.com/fsouza/go-dockerclient"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
 const (
+	defaultBaseImage = "busybox@sha256:5551dbdfc48d66734d0f01cafee0952cb6e8eeecd1e2492240bf2fd9640c2279"
+
 	healthcheckDockerfile = `
-FROM busybox@sha256:5551dbdfc48d66734d0f01cafee0952cb6e8eeecd1e2492240bf2fd9640c2279
-HEALTHCHECK --interval=1s --timeout=1s --retries=3 CMD echo hello
-CMD ["sh", "-c", "sleep %s"]
+FROM %[1]s
+HEALTHCHECK %[3]s
+CMD ["sh", "-c", "sleep %[2]s"]
 `
 	noHealthcheckdockerfile = `
-FROM busybox@sha256:5551dbdfc48d66734d0f01cafee0952cb6e8eeecd1e2492240bf2fd9640c2279
-#HEALTHCHECK --interval=1s --timeout=1s --retries=3 CMD echo hello
-CMD ["sh", "-c", "sleep %s"]
+FROM %[1]s
+#HEALTHCHECK %[3]s
+CMD ["sh", "-c", "sleep %[2]s"]
 `
 
 	callTimeoutSecs uint = 15
 	runDuration          = time.Second * 10
+
+	// toolLabel is stamped on the images the tool builds.
+	toolLabel = "health-stats-repro"
+	// runLabel is stamped on the containers the tool creates, with the
+	// run ID as its value, so leftovers on a shared host can be
+	// attributed to a run.
+	runLabel = "health-stats-repro.run"
+	// dockerfileLabel is stamped on the images the tool builds, with the
+	// digest of the Dockerfile they were built from as its value.
+	dockerfileLabel = "health-stats-repro.dockerfile"
 )
 
+// validRunID matches run IDs that are safe to use as a directory name.
+var validRunID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
 var (
 	progT time.Time
+	runID string
+
+	// logger carries the run_id on every line; use its With* methods
+	// to attach container_id, operation and duration where known.
+	logger    *logrus.Entry
+	logLevel  string
+	logFormat string
+	quiet     bool
+	verbose   bool
 
 	useHealthchecks  bool
+	noBuild          bool
+	forceRebuild     bool
 	healthCheckSleep string
+	dryRun           bool
 	stopContainers   bool
+	checkPause       bool
+	pauseTimeout     time.Duration
+	checkRestart     bool
+	restartTimeout   uint
 	removeContainers bool
+	removeForce      bool
+	removeVolumes    bool
+	removeImages     bool
+	streamStats      bool
+
+	withInit bool
+	// withTTY gives the containers a TTY, whose output the daemon
+	// streams as is instead of multiplexing stdout and stderr.
+	withTTY bool
+
+	statsLogEvery           int
+	statsLogMemoryThreshold uint64
+	statsLogPidsThreshold   uint64
+
+	scenario       string
+	containerCount int
+	startStagger   time.Duration
+
+	artifactRotation rotationPolicy
 
 	imageDockerfile      string
+	dockerfilePath       string
+	contextDir           string
+	contextDockerfile    string
+	customDockerfile     string
 	imageSleepTimeString string
+	baseImage            string
+	fixedRunID           string
+	minHostMemoryString  string
 	imageName            string
 )
 
+// engineInfo identifies the exact daemon build under test, as reported
+// by the version endpoint. Marketing versions alone aren't enough to
+// correlate repro rates across builds.
+type engineInfo struct {
+	Version          string   `json:"version"`
+	APIVersion       string   `json:"api_version"`
+	GitCommit        string   `json:"git_commit"`
+	GoVersion        string   `json:"go_version"`
+	KernelVersion    string   `json:"kernel_version"`
+	BuildTime        string   `json:"build_time"`
+	OperatingSystem  string   `json:"operating_system,omitempty"`
+	StorageDriver    string   `json:"storage_driver,omitempty"`
+	CgroupDriver     string   `json:"cgroup_driver,omitempty"`
+	ContainerdCommit string   `json:"containerd_commit,omitempty"`
+	RuncCommit       string   `json:"runc_commit,omitempty"`
+	LogDrivers       []string `json:"log_drivers,omitempty"`
+}
+
+// daemonInfo is the part of GET /info summarized in engineInfo. The
+// commits and log drivers aren't in the client's DockerInfo.
+type daemonInfo struct {
+	OperatingSystem  string
+	Driver           string
+	CgroupDriver     string
+	ContainerdCommit struct{ ID string }
+	RuncCommit       struct{ ID string }
+	Plugins          struct{ Log []string }
+}
+
 func init() {
 	progT = time.Now()
+	setRunID(newRunID())
+	results.Start = progT
+}
+
+// setRunID identifies the run as id, which names its artifacts
+// directory.
+func setRunID(id string) error {
+	if !validRunID.MatchString(id) {
+		return fmt.Errorf("invalid run ID %q", id)
+	}
+	runID = id
+	logger = logrus.WithField("run_id", runID)
+	results.RunID = runID
+	return nil
 }
 
 func main() {
-	flag.BoolVar(&useHealthchecks, "healthchecks", true, "Use HEALTHCHECK in container")
-	flag.BoolVar(&stopContainers, "stop-containers", true, "Stop run containers")
-	flag.BoolVar(&removeContainers, "remove-containers", true, "Remove run containers")
-	flag.StringVar(&imageSleepTimeString, "healthcheck-sleep-time", "2m", "Set `$ sleep <duration>` in healthcheck")
+	defer recoverAndExit()
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "clean":
+			cleanCommand(os.Args[2:])
+			return
+		case "history":
+			historyCommand(os.Args[2:])
+			return
+		case "stats":
+			statsCommand(os.Args[2:])
+			return
+		case "diff":
+			diffCommand(os.Args[2:])
+			return
+		case "serve":
+			serveCommand(os.Args[2:])
+			return
+		case "build":
+			buildCommand(os.Args[2:])
+			return
+		case "soak":
+			soakCommand(os.Args[2:])
+			return
+		case "ramp":
+			rampCommand(os.Args[2:])
+			return
+		}
+	}
+
+	registerFlags()
 	flag.Parse()
+	if fixedRunID != "" {
+		failOnError(setRunID(fixedRunID))
+	}
 
-	if useHealthchecks {
-		imageName = "docker-poke:healthchecks"
-		log.Println("Using Dockerfile with healthchecks")
-		imageDockerfile = healthcheckDockerfile
-	} else {
-		imageName = "docker-poke:no-healthchecks"
-		log.Println("Using Dockerfile WITHOUT healtchecks")
-		imageDockerfile = noHealthcheckdockerfile
+	if quiet && verbose {
+		failOnError(fmt.Errorf("--quiet and --verbose are mutually exclusive"))
+	}
+	level, err := logrus.ParseLevel(logLevel)
+	failOnError(err)
+	switch {
+	case quiet:
+		logrus.SetOutput(ioutil.Discard)
+	case verbose:
+		level = logrus.DebugLevel
+	}
+	logrus.SetLevel(level)
+	switch logFormat {
+	case "text":
+	case "json":
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		failOnError(fmt.Errorf("unknown log format %q", logFormat))
 	}
 
-	// Setup
-	cl, err := docker.NewClientFromEnv()
+	if !validScenario(scenario) {
+		failOnError(fmt.Errorf("unknown scenario %q", scenario))
+	}
+	if scenario == scenarioDependsOnHealthy && !useHealthchecks {
+		failOnError(fmt.Errorf("scenario %q requires healthchecks", scenario))
+	}
+	if chaosRate > 0 && chaosSeed == 0 {
+		chaosSeed = time.Now().UnixNano()
+	}
+	results.setChaosSeed(chaosSeed)
+	if scenario == scenarioExecFlood && execRate <= 0 {
+		failOnError(fmt.Errorf("scenario %q needs a positive --exec-rate", scenario))
+	}
+	if scenario == scenarioChurn && churnInterval <= 0 {
+		failOnError(fmt.Errorf("scenario %q needs a positive --churn-interval", scenario))
+	}
+
+	minHostMemory, err = units.RAMInBytes(minHostMemoryString)
+	failOnError(err)
+	failOnError(parseResources())
+	logOpts, err = parseLogOpts(logOptsSpec)
 	failOnError(err)
+	if logOpts != nil && logDriver == "" {
+		failOnError(fmt.Errorf("--log-opts need a --log-driver"))
+	}
+	if followLogs && !logsReadable() {
+		failOnError(fmt.Errorf("the logs of the %s log driver can't be followed", logDriver))
+	}
+	if containerCount < 1 {
+		failOnError(fmt.Errorf("--containers must be at least 1"))
+	}
+	if controlFraction < 0 || controlCount() >= containerCount {
+		failOnError(fmt.Errorf("--control-fraction must leave at least one container with a healthcheck"))
+	}
+	if experiment && (controlFraction != 0 || !useHealthchecks) {
+		failOnError(fmt.Errorf("--experiment picks the containers with healthchecks itself"))
+	}
+	if experiment && containerCount < len(cohorts) {
+		failOnError(fmt.Errorf("--experiment needs at least %d containers", len(cohorts)))
+	}
+	if !validHealthcheckBehavior(healthcheckBehavior) {
+		failOnError(fmt.Errorf("unknown healthcheck behavior %q", healthcheckBehavior))
+	}
+	failOnError(parseMounts())
+	failOnError(checkNetworkMode(networkMode))
+	if scenario == scenarioHTTPProbe {
+		failOnError(checkWorkloadProbe())
+		if networkMode == "host" || networkMode == "none" || strings.HasPrefix(networkMode, "container:") {
+			failOnError(fmt.Errorf("scenario %q publishes a port, which network mode %q doesn't", scenario, networkMode))
+		}
+		if workloadProbeInterval <= 0 {
+			failOnError(fmt.Errorf("scenario %q needs a positive --workload-probe-interval", scenario))
+		}
+	}
+	if healthcheckBehavior == healthcheckMountIO && mountPath() == "" {
+		failOnError(fmt.Errorf("healthcheck behavior %q needs a --tmpfs or --bind mount or --volume-ops", healthcheckBehavior))
+	}
+	if healthcheckInterval <= 0 || healthcheckTimeout <= 0 || healthcheckStartPeriod < 0 {
+		failOnError(fmt.Errorf("healthcheck interval and timeout must be positive, start period can't be negative"))
+	}
+	eventFilters, err = parseEventFilter(eventFilterSpec)
+	failOnError(err)
+	if notifyFormat != notifyJSON && notifyFormat != notifySlack {
+		failOnError(fmt.Errorf("unknown notification format %q", notifyFormat))
+	}
+	if s3Bucket != "" && !bundleArtifacts {
+		failOnError(fmt.Errorf("--s3-bucket needs --bundle"))
+	}
+	if hostLoadAction != hostLoadAnnotate && hostLoadAction != hostLoadAbort {
+		failOnError(fmt.Errorf("unknown host load action %q", hostLoadAction))
+	}
+
+	if maxQPS < 0 {
+		failOnError(fmt.Errorf("--max-qps can't be negative"))
+	}
+	if maxQPS > 0 {
+		apiLimiter = newTokenBucket(maxQPS)
+	}
+	if opsSpec == "" {
+		opsSpec = defaultOps()
+	}
+	checkOps, err = parseOps(opsSpec, time.Duration(callTimeoutSecs)*time.Second)
+	failOnError(err)
+	if restartPolicySpec == "" && scenario == scenarioExitRestart {
+		restartPolicySpec = "on-failure"
+	}
+	restartPolicy, err = parseRestartPolicy(restartPolicySpec)
+	failOnError(err)
+	if scenario == scenarioExitRestart && (restartPolicy.Name == "no" || exitAfter == 0) {
+		failOnError(fmt.Errorf("scenario %q needs a restart policy and a positive --exit-after", scenario))
+	}
+	if scenario == scenarioAutoRemove && restartPolicy.Name != "no" {
+		failOnError(fmt.Errorf("scenario %q can't be combined with a restart policy", scenario))
+	}
+	if scenario == scenarioAutoRemove && !hasCheckOp("kill") {
+		failOnError(fmt.Errorf("scenario %q needs the kill check, the containers are only removed once they exit", scenario))
+	}
+	if stopMode != stopModeKill && stopMode != stopModeStop && stopMode != stopModeEscalate {
+		failOnError(fmt.Errorf("unknown stop mode %q", stopMode))
+	}
+	escalationTimeouts, err = parseEscalationTimeouts(escalationTimeoutsSpec, time.Duration(callTimeoutSecs)*time.Second)
+	failOnError(err)
+	killSignalNumber, err = parseSignal(killSignal)
+	failOnError(err)
+	if containerStopSignal != "" {
+		_, err = parseSignal(containerStopSignal)
+		failOnError(err)
+	}
+	if replayPath != "" {
+		failOnError(loadReplay(replayPath))
+	}
+	if shuffle && seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	results.setSeed(seed)
+	if shuffle && scenario == scenarioDependsOnHealthy {
+		failOnError(fmt.Errorf("scenario %q starts the containers in order, it can't be shuffled", scenario))
+	}
+	failOnError(selectImage())
+
+	if dryRun {
+		printPlan(os.Stdout)
+		return
+	}
+
+	if err := writeRunConfig(); err != nil {
+		logger.WithError(err).Warn("Could not write run configuration")
+	}
+	if dashboardAddr != "" {
+		failOnError(serveDashboard(dashboardAddr))
+	}
+	logger.WithField("path", runDir()).Info("Writing artifacts")
 
-	log.Printf("Config stop container:\t%t", stopContainers)
-	log.Printf("Config remove container:\t%t", removeContainers)
+	handleSignals()
+	if hostLoadGuarded() {
+		checkHostLoad("before the run")
+	}
+	logger.Info("Starting run")
 
-	err = cl.BuildImage(buildImageOptions(imageName))
+	// Setup
+	cl, err := newClient()
 	failOnError(err)
 
+	engine, err := getEngineInfo(cl)
+	failOnError(err)
+	results.Engine = engine
+	dumpPayload(logger, "version", engine)
+	logger.WithFields(logrus.Fields{
+		"version":           engine.Version,
+		"api_version":       engine.APIVersion,
+		"git_commit":        engine.GitCommit,
+		"go_version":        engine.GoVersion,
+		"kernel_version":    engine.KernelVersion,
+		"build_time":        engine.BuildTime,
+		"storage_driver":    engine.StorageDriver,
+		"containerd_commit": engine.ContainerdCommit,
+		"runc_commit":       engine.RuncCommit,
+	}).Info("Engine version")
+	checkEngineVersion(engine.Version)
+	failOnError(checkLogDriver(engine.LogDrivers))
+
+	logger.WithFields(logrus.Fields{
+		"ops":          opsSpec,
+		"stream_stats": streamStats,
+		"experiment":   experiment,
+		"scenario":     scenario,
+	}).Info("Config")
+
+	startPprof(cl)
+
+	if heartbeatInterval > 0 {
+		heartbeatCtx, stopHeartbeat := context.WithCancel(rootCtx)
+		goSafe(func() {
+			heartbeat(heartbeatCtx, cl, heartbeatInterval, heartbeatTimeout)
+		})
+		onTeardown(phaseStreams, "heartbeat", func(context.Context) error {
+			stopHeartbeat()
+			return nil
+		})
+	}
+
+	if hostLoadGuarded() && hostLoadInterval > 0 {
+		loadCtx, stopLoad := context.WithCancel(rootCtx)
+		goSafe(func() {
+			guardHostLoad(loadCtx, hostLoadInterval)
+		})
+		onTeardown(phaseStreams, "host-load", func(context.Context) error {
+			stopLoad()
+			return nil
+		})
+	}
+
+	if daemonProcInterval > 0 {
+		if strings.HasPrefix(cl.Endpoint(), "unix://") {
+			procCtx, stopProcs := context.WithCancel(rootCtx)
+			goSafe(func() {
+				sampleDaemonProcs(procCtx, daemonProcInterval)
+			})
+			onTeardown(phaseStreams, "daemon-procs", func(context.Context) error {
+				stopProcs()
+				return nil
+			})
+		} else {
+			logger.Warn("Daemon is not local, not sampling its processes")
+		}
+	}
+
+	if daemonRestartPoll > 0 {
+		monitorCtx, stopMonitor := context.WithCancel(rootCtx)
+		restarts, err := watchDaemonRestarts(monitorCtx, cl, daemonRestartPoll)
+		if err != nil {
+			logger.WithError(err).Warn("Could not record daemon identity, restarts will not be detected")
+			stopMonitor()
+		} else {
+			onTeardown(phaseStreams, "restart-monitor", func(context.Context) error {
+				stopMonitor()
+				// Catch a restart since the last poll.
+				restarts.check()
+				return nil
+			})
+		}
+	}
+
+	eventsOut := logFile("events.ndjson")
+	eventLog, err = watchEvents(cl, eventsOut)
+	if err != nil {
+		logger.WithError(err).Warn("Could not listen for events, snapshots will not include them")
+		eventsOut.Close()
+	} else {
+		onTeardown(phaseStreams, "events", func(context.Context) error {
+			eventLog.stop(cl)
+			return nil
+		})
+	}
+
+	failOnError(prepareImage(cl))
+
 	// Repro case:
 	//
 	// It appears that when containers that run with healthchecks and
@@ -105,163 +485,873 @@ func main() {
 	//
 	// EDIT 2018-03-21: Stats streaming isn't necessary for the bug to manifest.
 
-	// Create some containers
-	cont1, err := createContainer(cl)
-	failOnError(err)
+	// Create some containers, the last ones as controls without a
+	// healthcheck if asked for, or split into an experiment's cohorts,
+	// and start them.
+	rng := scheduleRand()
+	var conts, streamed []*docker.Container
+	if rng != nil {
+		conts, streamed = createAndStartShuffled(cl, rng)
+	} else {
+		conts, streamed = createAndStart(cl)
+	}
 
-	cont2, err := createContainer(cl)
-	failOnError(err)
+	for _, cont := range conts {
+		if followLogs {
+			startLogFollower(cl, cont)
+		}
+		if attachFollowers {
+			startAttachFollower(cl, cont, time.Duration(callTimeoutSecs)*time.Second)
+		}
+	}
 
-	// Start some containers
-	err = cl.StartContainer(cont1.ID, nil)
-	failOnError(err)
+	statsCtx, stopStats := context.WithCancel(rootCtx)
+	statsDone := make(chan struct{})
+	if len(streamed) != 0 {
+		statsOut := logFile("stats.ndjson")
+		goSafe(func() {
+			logStatsForContainers(statsCtx, statsOut, cl, streamed...)
+			statsOut.Close()
+			close(statsDone)
+		})
+		onTeardown(phaseStreams, "stats", func(context.Context) error {
+			stopStats()
+			<-statsDone
+			return nil
+		})
+	} else {
+		close(statsDone)
+	}
 
-	err = cl.StartContainer(cont2.ID, nil)
-	if err != nil {
-		// stop the other container and then exit.
-		stopAndCheckContainer(cl, cont1)
+	// The workloads are probed until the containers are checked, so
+	// that probes made while the checks hang are recorded.
+	probeCtx, stopProbes := context.WithCancel(rootCtx)
+	probesDone := make(chan struct{})
+	if scenario == scenarioHTTPProbe {
+		goSafe(func() {
+			probeWorkloads(probeCtx, cl, conts, workloadProbeInterval)
+			close(probesDone)
+		})
+		onTeardown(phaseStreams, "workload probes", func(context.Context) error {
+			stopProbes()
+			<-probesDone
+			return nil
+		})
+	} else {
+		close(probesDone)
+	}
+
+	var network *docker.Network
+	if networkChurnInterval > 0 {
+		network, err = createRunNetwork(cl)
+		if classifyError(err) == errClassTimeout {
+			exit(2, "FAIL: creating the run's network hung")
+		}
 		failOnError(err)
 	}
 
-	conts := []*docker.Container{
-		cont1,
-		cont2,
+	loadCtx, stopLoad := context.WithCancel(rootCtx)
+	if inspectQPS > 0 {
+		goSafe(func() {
+			inspectLoad(loadCtx, cl, conts, inspectQPS)
+		})
+	}
+	if chaosRate > 0 {
+		goSafe(func() {
+			chaos(loadCtx, cl, conts, chaosRate, chaosSeed)
+		})
+	}
+	if verifyInterval > 0 {
+		verifyContainers(loadCtx, cl, conts, verifyInterval)
+	}
+	// Chaos and churn stop containers, which ends their stats.
+	if statsStallFactor > 0 && len(streamed) != 0 && chaosRate == 0 && !restartsContainers() {
+		goSafe(func() {
+			watchStatsStalls(loadCtx, cl, streamed, statsStallFactor)
+		})
+	}
+	if topInterval > 0 {
+		goSafe(func() {
+			sampleTop(loadCtx, cl, conts, topInterval)
+		})
+	}
+	if scenario == scenarioExecFlood {
+		goSafe(func() {
+			execFlood(loadCtx, cl, conts, execRate)
+		})
+	}
+	if network != nil {
+		goSafe(func() {
+			churnNetwork(loadCtx, cl, conts, network, networkChurnInterval)
+		})
+	}
+	if scenario == scenarioChurn {
+		var churned []*docker.Container
+		for _, cont := range conts {
+			if !results.isControl(cont.ID) {
+				churned = append(churned, cont)
+			}
+		}
+		goSafe(func() {
+			churn(loadCtx, cl, churned, churnInterval)
+		})
 	}
 
 	// Run the containers for some time.
-	log.Printf("Waiting for %s", runDuration)
-	time.Sleep(runDuration)
+	logger.Infof("Waiting for %s", runDuration)
+	failOnError(sleepCtx(rootCtx, runDuration))
+	stopLoad()
 
 	// Check the containers that were run.
 	affected := []*docker.Container{}
-	for _, cont := range conts {
-		err = stopAndCheckContainer(cl, cont)
-		if err != nil {
+	unverified := []*docker.Container{}
+	errs := checkContainers(cl, conts, rng)
+	for i, cont := range conts {
+		err = errs[i]
+		if err == nil && isAffected(cont.ID) {
+			// A hang while the run waited, a stalled stats stream
+			// included, affects the container even if it came back.
+			err = errHungWhileWaiting
+		}
+		switch {
+		case err == nil:
+		case classifyError(err) == errClassThrottled:
+			// The daemon shed the call, that says nothing about
+			// whether it would have hung.
+			unverified = append(unverified, cont)
+			results.setVerdict(cont.ID, verdictUnverified)
+		default:
 			affected = append(affected, cont)
+			results.setVerdict(cont.ID, verdictAffected)
+			markAffected(cont.ID)
+			snapshotOnDetection(cl, eventLog, fmt.Sprintf("container %s affected: %s", cont.ID, err))
 		}
 	}
+	stopStats()
+	<-statsDone
+	stopProbes()
+	<-probesDone
+
+	// The stopped containers are no longer attached to the network.
+	networkHung := network != nil && classifyError(removeRunNetwork(cl, network)) == errClassTimeout
+
+	if n := atomic.LoadInt64(&throttledResponses); n != 0 {
+		logger.Warnf("Daemon throttled %d request(s) during the run", n)
+	}
 
 	if len(affected) != 0 {
-		log.Printf("Run affected %d container(s):", len(affected))
-		for _, c := range affected {
-			fmt.Printf("# docker inspect %s\n", c.ID)
+		saved := inspectAffected(affected)
+		logger.Errorf("Run affected %d container(s)", len(affected))
+		if path, err := writeDiagnoseScript(affected, saved); err != nil {
+			logger.WithError(err).Error("Could not write diagnostics script")
+		} else if !quiet {
+			fmt.Printf("# sh %s\n", path)
+		}
+		if n := results.affectedControls(); n != 0 {
+			logger.Warnf("%d control container(s) without a healthcheck were affected too", n)
 		}
-		os.Exit(2)
+		exit(2, fmt.Sprintf("FAIL: run affected %d container(s)", len(affected)))
+	}
+	if networkHung {
+		exit(2, "FAIL: removing the run's network hung")
 	}
+	if len(unverified) != 0 {
+		exit(3, fmt.Sprintf("INCONCLUSIVE: daemon throttled checks of %d container(s)", len(unverified)))
+	}
+	exit(0, "PASS: no containers affected")
+}
+
+// registerFlags registers the flags of a run.
+func registerFlags() {
+	flag.StringVar(&fixedRunID, "run-id", "", "Use this run ID instead of generating one")
+	flag.BoolVar(&useHealthchecks, "healthchecks", true, "Use HEALTHCHECK in container")
+	flag.BoolVar(&useBuildKit, "buildkit", false, "Build the test image with BuildKit instead of the legacy builder, falling back to the legacy builder if the daemon doesn't advertise BuildKit or refuses the build. The build context is uploaded with the request, BuildKit sessions aren't supported")
+	flag.BoolVar(&forceRebuild, "force-rebuild", false, "Build the image even if one built from the same Dockerfile exists")
+	flag.BoolVar(&noBuild, "no-build", false, "Use the image of an earlier build subcommand instead of building it, failing if it is missing")
+	flag.BoolVar(&dryRun, "dry-run", false, "Print the Docker operations the run would make without contacting the daemon")
+	flag.BoolVar(&failFast, "fail-fast", false, "Abort the run on the first kill or inspect call that exceeds its timeout")
+	flag.BoolVar(&strictVersionCheck, "strict-version-check", false, "Skip the run, as inconclusive, on engine versions known not to hang")
+	flag.BoolVar(&dumpDaemonStacks, "dump-daemon-stacks", false, "On a hang, send SIGUSR1 to a local dockerd so it dumps its goroutine stacks (needs privileges to signal it)")
+	flag.BoolVar(&daemonPprof, "daemon-pprof", true, "Collect dockerd pprof profiles at run start, on the first hang and at run end, if the daemon runs with debug enabled")
+	flag.StringVar(&containerdDebugSocket, "containerd-debug-socket", "", "Also collect containerd pprof profiles from this debug socket (e.g. /run/containerd/debug.sock)")
+	flag.DurationVar(&heartbeatInterval, "heartbeat-interval", 2*time.Second, "How often to ping the daemon in the background (0 disables)")
+	flag.DurationVar(&heartbeatTimeout, "heartbeat-timeout", 2*time.Second, "Timeout of each background ping")
+	flag.Float64Var(&maxHostLoad, "max-host-load", 0, "Highest 1-minute load average per CPU to run under (0 disables)")
+	flag.StringVar(&minHostMemoryString, "min-host-memory", "0", "Least available host memory to run with, e.g. 512m (0 disables)")
+	flag.StringVar(&hostLoadAction, "host-load-action", hostLoadAnnotate, "What to do when the host crosses a load threshold (annotate, abort)")
+	flag.DurationVar(&hostLoadInterval, "host-load-interval", 10*time.Second, "How often to check host load during the run")
+	flag.DurationVar(&daemonProcInterval, "daemon-proc-interval", 0, "Sample the RSS, CPU and threads of a local dockerd and containerd this often (0 disables)")
+	flag.StringVar(&notifyURL, "notify-url", "", "POST a notification to this URL when the first hang is detected")
+	flag.StringVar(&notifyFormat, "notify-format", notifyJSON, "Notification payload format (json, slack)")
+	flag.StringVar(&dashboardAddr, "dashboard-addr", "", "Serve a live dashboard of the run on this address, e.g. localhost:8080")
+	flag.BoolVar(&issueReport, "issue-report", false, "At exit, write a Markdown issue body for the run to issue.md in its directory")
+	flag.BoolVar(&bundleArtifacts, "bundle", true, "At exit, bundle the run's artifacts into health-stats-repro-<run-id>.tar.gz")
+	flag.StringVar(&s3Bucket, "s3-bucket", "", "Upload the artifact bundle to this S3 bucket, with credentials from the standard AWS chain")
+	flag.StringVar(&s3Prefix, "s3-prefix", "", "Key prefix of uploaded bundles, e.g. repro/host-1/")
+	flag.StringVar(&s3Region, "s3-region", "", "Region of --s3-bucket (default $AWS_REGION, or us-east-1)")
+	flag.BoolVar(&collectDaemonLogs, "collect-daemon-logs", false, "At exit, save the daemon's configuration and its journal since the run started")
+	flag.StringVar(&daemonConfigPath, "daemon-config", "/etc/docker/daemon.json", "Daemon configuration file saved with --collect-daemon-logs")
+	flag.StringVar(&daemonUnit, "daemon-unit", "docker", "Systemd unit whose journal is saved with --collect-daemon-logs")
+	flag.DurationVar(&daemonRestartPoll, "daemon-restart-poll", 5*time.Second, "How often to check whether the daemon restarted, which invalidates the run (0 disables)")
+	flag.BoolVar(&stopContainers, "stop-containers", true, "Stop run containers")
+	flag.Float64Var(&cadenceTolerance, "cadence-tolerance", 3, "Flag containers that go this many healthcheck intervals (plus the timeout) without a probe (0 disables)")
+	flag.BoolVar(&checkExecIDs, "check-exec-ids", false, "Inspect each container for its exec list before stopping it, to find leaked healthcheck execs")
+	flag.BoolVar(&checkPause, "check-pause", false, "Pause and unpause each container before stopping it")
+	flag.DurationVar(&pauseTimeout, "pause-timeout", 15*time.Second, "Timeout of the pause and unpause calls")
+	flag.BoolVar(&checkRestart, "check-restart", false, "Restart each container before stopping it")
+	flag.UintVar(&restartTimeout, "restart-timeout", 1, "Seconds a restart waits for the container to stop before killing it")
+	flag.BoolVar(&removeContainers, "remove-containers", true, "Remove run containers")
+	flag.BoolVar(&removeForce, "remove-force", false, "Force the removal of the run containers, even if they are still running")
+	flag.BoolVar(&removeVolumes, "remove-volumes", false, "Remove the volumes of the run containers with them")
+	flag.BoolVar(&removeImages, "remove-images", false, "Remove the test image at exit if the run built it, rather than keeping it for the next run")
+	flag.StringVar(&stopMode, "stop-mode", stopModeKill, "How the kill check stops the containers: kill sends --kill-signal, stop stops them gracefully, escalate stops, then kills, then force removes them until a step works")
+	flag.StringVar(&escalationTimeoutsSpec, "escalation-timeouts", "", "With --stop-mode escalate, the timeouts of the stop, kill and force remove, eg. 30s,15s,15s (default the call timeout each, plus --stop-timeout for the stop)")
+	flag.UintVar(&stopTimeout, "stop-timeout", 10, "With --stop-mode stop, seconds to wait before the daemon kills the container")
+	flag.StringVar(&killSignal, "kill-signal", "KILL", "With --stop-mode kill, the signal to send")
+	flag.Float64Var(&containerCPUs, "container-cpus", 0, "CPUs each container may use, eg. 0.25 (0 for no limit)")
+	flag.StringVar(&containerMemoryString, "container-memory", "", "Memory each container may use, eg. 64m (no limit by default)")
+	flag.Int64Var(&containerPidsLimit, "container-pids-limit", 0, "Processes each container may run, healthcheck execs included (0 for no limit)")
+	flag.StringVar(&containerUlimitsSpec, "container-ulimits", "", "Ulimits of the containers, comma separated, eg. nofile=256:512,nproc=64")
+	flag.StringVar(&logDriver, "log-driver", "", "Log driver of the containers, eg. json-file, journald, none or awslogs, if the daemon has it (default the daemon's)")
+	flag.StringVar(&logOptsSpec, "log-opts", "", "Options of --log-driver, comma separated, eg. max-size=1m,max-file=2")
+	flag.StringVar(&networkMode, "network-mode", "", "Network mode of the containers: bridge, host, none, container:<name|id> or a network's name (default the daemon's)")
+	flag.DurationVar(&networkChurnInterval, "network-churn-interval", 0, "Create a network for the run and connect and disconnect the containers one after the other this often while the run waits (0 disables)")
+	flag.BoolVar(&volumeOps, "volume-ops", false, "Create a volume for each container, mounted at /volume, and remove it once the container is removed")
+	flag.StringVar(&tmpfsSpec, "tmpfs", "", "tmpfs mounts of the containers, semicolon separated, each a path and its options, eg. /data:size=16m,noexec")
+	flag.StringVar(&bindsSpec, "bind", "", "Bind mounts of the containers, comma separated, eg. /var/tmp/repro:/data:ro")
+	flag.BoolVar(&withTTY, "tty", false, "Allocate a TTY for the containers, which changes how the daemon streams their logs and attaches")
+	flag.BoolVar(&withInit, "init", false, "Run an init process in the containers that reaps their zombies, healthcheck shells among them")
+	flag.StringVar(&containerStopSignal, "container-stop-signal", "", "Stop signal of the containers, sent before they are killed on a graceful stop (default the daemon's, SIGTERM)")
+	flag.UintVar(&containerStopTimeout, "container-stop-timeout", 0, "Stop timeout of the containers, in seconds, used when the daemon stops them without being told how long to wait, eg. on shutdown (0 for the daemon's default)")
+	flag.StringVar(&opsSpec, "ops", "", "Calls to check each container with once the run is over, each with an optional timeout, eg. inspect,kill:30s,top (of top, logs, kill, wait, inspect, remove; default as --stop-containers and --remove-containers say)")
+	flag.StringVar(&imageRef, "image", "", "Pull this prebuilt image and run it instead of building the test image")
+	flag.StringVar(&dockerfilePath, "dockerfile", "", "Build the test image from this Dockerfile instead of the embedded one")
+	flag.StringVar(&contextDir, "context-dir", "", "Build the test image from this build context directory, with its Dockerfile unless --dockerfile names another one in it")
+	flag.StringVar(&baseImage, "base-image", defaultBaseImage, "Base image of the generated Dockerfile, which needs a shell")
+	flag.IntVar(&containerCount, "containers", 2, "Number of test containers to run")
+	flag.Float64Var(&chaosRate, "chaos", 0, "Make this many random pause/unpause, restart, kill or rename operations a second against the containers while the run waits (0 disables)")
+	flag.BoolVar(&shuffle, "shuffle", false, "Interleave the creates, starts and checks of the containers in a random order")
+	flag.Int64Var(&seed, "seed", 0, "With --shuffle, seed of the order, to repeat one (0 picks one)")
+	flag.StringVar(&replayPath, "replay", "", "Replay the order and timing of the creates, starts and checks of a shuffled run from its results.json")
+	flag.Int64Var(&chaosSeed, "chaos-seed", 0, "Seed of the chaos operations, to repeat a sequence (0 picks one)")
+	flag.DurationVar(&startStagger, "start-stagger", 0, "Wait this long between container starts")
+	flag.DurationVar(&topInterval, "top-interval", 0, "List the processes of the containers this often while the run waits (0 disables)")
+	flag.IntVar(&topLeakThreshold, "top-leak-threshold", 3, "Flag containers running this many healthcheck or exec processes at once")
+	flag.DurationVar(&verifyInterval, "verify-interval", 0, "Inspect each container this often while the run waits, to detect and alert on a hang right away (0 disables)")
+	flag.Float64Var(&inspectQPS, "inspect-qps", 0, "Inspect the containers at this rate while the run waits (0 disables)")
+	flag.Float64Var(&controlFraction, "control-fraction", 0, "Fraction of the containers to create with their healthcheck disabled, as controls")
+	flag.BoolVar(&experiment, "experiment", false, "Split the containers into cohorts with and without a healthcheck and stats streaming, and compare how many of each were affected")
+	flag.BoolVar(&healthcheckAtCreate, "healthcheck-at-create", false, "Set the healthcheck on the containers' config at create instead of in the image")
+	flag.StringVar(&healthcheckBehavior, "healthcheck-behavior", healthcheckSucceed, "What the healthcheck does (succeed, fail, flap, slow, exec, mount-io)")
+	flag.DurationVar(&healthcheckInterval, "healthcheck-interval", time.Second, "HEALTHCHECK --interval of the generated Dockerfile")
+	flag.DurationVar(&healthcheckTimeout, "healthcheck-timeout", time.Second, "HEALTHCHECK --timeout of the generated Dockerfile")
+	flag.UintVar(&healthcheckRetries, "healthcheck-retries", 3, "HEALTHCHECK --retries of the generated Dockerfile")
+	flag.DurationVar(&healthcheckStartPeriod, "healthcheck-start-period", 0, "HEALTHCHECK --start-period of the generated Dockerfile (0 leaves it out)")
+	flag.StringVar(&imageSleepTimeString, "healthcheck-sleep-time", "2m", "Set `$ sleep <duration>` in healthcheck")
+	flag.BoolVar(&streamStats, "stream-stats", false, "Stream stats from run containers to a stats data file")
+	flag.BoolVar(&attachFollowers, "attach", false, "Attach to the output of the run containers and check the streams end once they exit")
+	flag.BoolVar(&followLogs, "follow-logs", false, "Follow the logs of the run containers and check the streams end once they exit")
+	flag.DurationVar(&statsPollInterval, "stats-poll-interval", 0, "With --stream-stats, poll one-shot stats (stream=false) this often instead of streaming them (0 streams)")
+	flag.Float64Var(&statsStallFactor, "stats-stall-factor", 5, "With --stream-stats, treat a container as affected when its stats go this many intervals without a sample (0 disables)")
+	flag.IntVar(&statsSampleEvery, "stats-sample-every", 1, "With --stream-stats, write every Nth stats sample per container to the stats data file")
+	flag.BoolVar(&statsCompact, "stats-compact", true, "With --stream-stats, write the CPU%, memory% and pids of each stats sample rather than the raw sample")
+	flag.StringVar(&eventFilterSpec, "event-filter", "", "Only write the events matching these comma separated filters to the events log: type=, event=, container=, label=<key>[=<value>], or run for the run's containers")
+	flag.IntVar(&statsLogEvery, "stats-log-every", 10, "With --stream-stats, log every Nth stats sample per container (0 disables)")
+	flag.Uint64Var(&statsLogMemoryThreshold, "stats-log-memory-threshold", 0, "With --stream-stats, log samples where memory usage crosses this many bytes (0 disables)")
+	flag.Uint64Var(&statsLogPidsThreshold, "stats-log-pids-threshold", 0, "With --stream-stats, log samples where the pids count crosses this value (0 disables)")
+	flag.StringVar(&scenario, "scenario", scenarioParallel, "Container start scenario (parallel, depends-on-healthy, churn, exec-flood, auto-remove, exit-restart, http-probe)")
+	flag.Float64Var(&execRate, "exec-rate", 5, "With the exec-flood scenario, execs a second to make in each container")
+	flag.UintVar(&exitAfter, "exit-after", 5, "With the exit-restart scenario, seconds the containers run before they exit")
+	flag.StringVar(&restartPolicySpec, "restart-policy", "", "Restart policy of the containers: no, on-failure[:max-retries], always or unless-stopped (default no, on-failure with the exit-restart scenario)")
+	flag.DurationVar(&workloadProbeInterval, "workload-probe-interval", time.Second, "With the http-probe scenario, how often to probe the containers' listeners, each probe bounded by it too")
+	flag.DurationVar(&churnInterval, "churn-interval", 5*time.Second, "With the churn scenario, how long to wait between stopping and restarting one container and the next")
+	flag.Float64Var(&maxQPS, "max-qps", 0, "Limit requests to the daemon to this many a second, with bursts of a second's worth; waiting for the limit counts against a call's timeout (0 disables)")
+	flag.IntVar(&throttleRetries, "throttle-retries", 3, "Times to retry a request the daemon throttled (429/503 with Retry-After)")
+	flag.DurationVar(&throttleMaxWait, "throttle-max-wait", 30*time.Second, "Longest Retry-After to honor before giving up on a throttled request")
+	flag.BoolVar(&cleanupOnInterrupt, "cleanup-on-interrupt", true, "Kill and remove run containers when interrupted")
+	flag.StringVar(&logLevel, "log-level", "info", "Log level (debug, info, warn, error)")
+	flag.StringVar(&logFormat, "log-format", "text", "Log format (text, json)")
+	flag.BoolVar(&quiet, "quiet", false, "Only print the final verdict and exit code")
+	flag.BoolVar(&verbose, "verbose", false, "Log at debug level, including full API payloads and every stats sample")
+	flag.Int64Var(&artifactRotation.MaxSize, "artifact-max-size", 64<<20, "Rotate stats and events logs once they reach this many bytes (0 disables)")
+	flag.DurationVar(&artifactRotation.MaxAge, "artifact-max-age", 0, "Rotate stats and events logs once they are this old (0 disables)")
+	flag.IntVar(&artifactRotation.Keep, "artifact-keep", 10, "Number of rotated stats and events log segments to keep (0 keeps all)")
+	flag.BoolVar(&artifactRotation.Compress, "artifact-gzip", false, "Gzip rotated stats and events log segments")
+}
+
+// createAndStart creates the run's containers and starts them one after
+// the other. It returns the containers, and those to stream stats from.
+func createAndStart(client *docker.Client) (conts, streamed []*docker.Container) {
+	for i := 0; i < containerCount; i++ {
+		control, cohortName, stats := containerRole(i)
+		cont, err := createContainer(client, control, cohortName)
+		failOnError(err)
+		conts = append(conts, cont)
+		if stats {
+			streamed = append(streamed, cont)
+		}
+	}
+
+	// Start some containers
+	err := client.StartContainerWithContext(conts[0].ID, nil, rootCtx)
+	failOnError(err)
+
+	if scenario == scenarioDependsOnHealthy {
+		// Hold the other containers back until the first is healthy.
+		err = waitForHealthy(client, conts[0])
+		if err != nil && isAffected(conts[0].ID) {
+			exit(2, fmt.Sprintf("FAIL: container %s hung while waiting for it to become healthy", conts[0].ID))
+		}
+		failOnError(err)
+	}
+
+	for _, cont := range conts[1:] {
+		// Spacing the starts out keeps the containers' healthchecks
+		// from being scheduled in lockstep.
+		failOnError(sleepCtx(rootCtx, startStagger))
+		err = client.StartContainerWithContext(cont.ID, nil, rootCtx)
+		failOnError(err)
+	}
+	return conts, streamed
 }
 
 func stopAndCheckContainer(client *docker.Client, cont *docker.Container) error {
-	if stopContainers {
-		// Try to stop the container
-		ctx, _ := context.WithTimeout(context.Background(), time.Duration(callTimeoutSecs)*time.Second)
+	check := &containerCheck{cont: cont}
+	check.prepare(client)
+	for _, op := range checkOps {
+		if check.done {
+			break
+		}
+		check.run(client, op)
+	}
+	return check.err
+}
+
+// containerCheck is the state of the checks of a container once the
+// run is over.
+type containerCheck struct {
+	cont   *docker.Container
+	killed bool
+	// err is the failed check that affected the container, which is
+	// done then and isn't checked any further.
+	err  error
+	done bool
+}
+
+func (c *containerCheck) fail(err error) {
+	c.err = err
+	c.done = true
+}
+
+// prepare makes the checks that come before the ones of --ops.
+func (c *containerCheck) prepare(client *docker.Client) {
+	cont := c.cont
+	callTimeout := time.Duration(callTimeoutSecs) * time.Second
+
+	if checkExecIDs {
+		inspectExecIDs(client, cont)
+	}
 
-		err := client.KillContainer(docker.KillContainerOptions{
-			Context: ctx,
-			ID:      cont.ID,
+	if checkPause {
+		// Pausing a container mid healthcheck is a suspected
+		// deadlock of its own.
+		olog, err := timedOp(client, cont, "pause", pauseTimeout, func(context.Context) error {
+			return client.PauseContainer(cont.ID)
 		})
 		if err != nil {
-			log.Printf("Could not stop container %q", cont.ID)
-			log.Printf("Will try to inspect container %q", cont.ID)
+			olog.Warn("Could not pause container")
+		} else {
+			olog, err = timedOp(client, cont, "unpause", pauseTimeout, func(context.Context) error {
+				return client.UnpauseContainer(cont.ID)
+			})
+			if err != nil {
+				olog.Warn("Could not unpause container")
+			}
 		}
 	}
 
-	// Inspect run containers
-	ctx, _ := context.WithTimeout(context.Background(), time.Duration(callTimeoutSecs)*time.Second)
-	insp, err := client.InspectContainerWithContext(cont.ID, ctx)
-	if err != nil {
-		log.Printf("Error inspecting container: %s", err)
-		return err
+	if checkRestart {
+		// The restart has to stop the container first, which may take
+		// the whole of its timeout.
+		timeout := callTimeout + time.Duration(restartTimeout)*time.Second
+		olog, err := timedOp(client, cont, "restart", timeout, func(context.Context) error {
+			return client.RestartContainer(cont.ID, restartTimeout)
+		})
+		if err != nil {
+			olog.Warn("Could not restart container")
+		} else {
+			olog.Debug("Restarted container")
+		}
 	}
-	log.Printf("Successfully inspected container %q", insp.ID)
+}
 
-	if removeContainers {
-		log.Printf("Trying to remove container %q", insp.ID)
-		err = client.RemoveContainer(docker.RemoveContainerOptions{
-			ID: cont.ID,
+// run makes the check op against the container.
+func (c *containerCheck) run(client *docker.Client, op checkOp) {
+	cont := c.cont
+	clog := logger.WithField("container_id", cont.ID)
+	switch op.name {
+	case "top":
+		olog, err := timedOp(client, cont, "top", op.timeout, func(context.Context) error {
+			_, err := client.TopContainer(cont.ID, "")
+			return err
 		})
 		if err != nil {
-			log.Printf("Could not remove container %q", insp.ID)
-			return err
+			olog.Warn("Could not list container processes")
+		}
+	case "logs":
+		olog, err := timedOp(client, cont, "logs", op.timeout, func(ctx context.Context) error {
+			return client.Logs(docker.LogsOptions{
+				Context:      ctx,
+				Container:    cont.ID,
+				OutputStream: ioutil.Discard,
+				ErrorStream:  ioutil.Discard,
+				Stdout:       true,
+				Stderr:       true,
+				Tail:         "10",
+				RawTerminal:  withTTY,
+			})
+		})
+		if err != nil {
+			olog.Warn("Could not get container logs")
+		}
+	case "kill":
+		if stopMode == stopModeEscalate {
+			step, _ := escalate(client, cont)
+			if step == escalateForceRemove {
+				// The container is gone, there is nothing left
+				// to check.
+				c.done = true
+				return
+			}
+			c.killed = step != ""
+		} else {
+			// A container's main process may ignore other signals
+			// than SIGKILL, it only has to exit when it got that.
+			c.killed = stopContainer(client, cont, op.timeout) == nil &&
+				(stopMode == stopModeStop || killSignalNumber == docker.SIGKILL)
+		}
+		if c.killed && !hasCheckOp("wait") {
+			checkFollowersEnded(client, cont, op.timeout)
+		}
+		if c.killed && scenario == scenarioAutoRemove {
+			if err := waitForAutoRemove(client, cont, op.timeout); err != nil {
+				c.fail(err)
+				return
+			}
+			// The container is gone, there is nothing left to check.
+			c.done = true
+		}
+	case "wait":
+		// Only a killed container is going to exit.
+		if c.killed || !hasCheckOp("kill") {
+			waitForExit(client, cont, op.timeout)
+			checkFollowersEnded(client, cont, op.timeout)
+		}
+	case "inspect":
+		if err := inspectContainer(client, cont, op.timeout); err != nil {
+			c.fail(err)
+		}
+	case "remove":
+		clog.Debug("Trying to remove container")
+		olog, err := timedOp(client, cont, "remove", op.timeout, func(ctx context.Context) error {
+			return client.RemoveContainer(docker.RemoveContainerOptions{
+				Context:       ctx,
+				ID:            cont.ID,
+				Force:         removeForce,
+				RemoveVolumes: removeVolumes,
+			})
+		})
+		if err != nil {
+			olog.Error("Could not remove container")
+			c.fail(err)
+			return
+		}
+		olog.Info("Removed container")
+		if volume := volumeOf(cont.ID); volume != "" {
+			if err := removeVolume(client, cont, volume, op.timeout); err != nil {
+				c.fail(err)
+			}
 		}
-		log.Printf("Removed container %q", insp.ID)
 	}
-	return err
+}
+
+// inspectContainer inspects cont, bounded by timeout, and records and
+// saves what it found.
+func inspectContainer(client *docker.Client, cont *docker.Container, timeout time.Duration) error {
+	clog := logger.WithField("container_id", cont.ID)
+	var insp *docker.Container
+	ctx, throttling := withThrottleRecord(rootCtx)
+	start := time.Now()
+	err := watchCall(ctx, cont.ID, "inspect", timeout, func(ctx context.Context) (err error) {
+		insp, err = client.InspectContainerWithContext(cont.ID, ctx)
+		return err
+	})
+	err = attributeThrottling(err, throttling)
+	olog := finishOp(clog, cont.ID, "inspect", start, err)
+	if classifyError(err) == errClassTimeout {
+		hangDetected(client, cont, "inspect", err)
+	}
+	failFastOnHang(cont, "inspect", err)
+	if err != nil {
+		olog.Error("Error inspecting container")
+		return err
+	}
+	results.recordHealth(cont.ID, insp.State.Health.Status)
+	results.recordRestarts(cont.ID, insp.RestartCount)
+	noteExecIDs(insp)
+	olog.Info("Successfully inspected container")
+	dumpPayload(olog, "inspect", insp)
+	if err := writeInspect(insp); err != nil {
+		olog.WithError(err).Warn("Could not save inspect document")
+	}
+	return nil
+}
+
+// waitForExit confirms that a killed container really exited, which
+// the kill call returning doesn't, and records its exit code.
+func waitForExit(client *docker.Client, cont *docker.Container, timeout time.Duration) {
+	var code int
+	olog, err := timedOp(client, cont, "wait", timeout, func(ctx context.Context) (err error) {
+		code, err = client.WaitContainerWithContext(cont.ID, ctx)
+		return err
+	})
+	if err != nil {
+		olog.Warn("Could not confirm the container exited after it was killed")
+		return
+	}
+	results.recordExit(cont.ID, code)
+	olog.WithField("exit_code", code).Debug("Container exited")
+}
+
+// timedOp makes the call op against cont, bounded by timeout, records
+// its outcome and reports it if it hung.
+func timedOp(client *docker.Client, cont *docker.Container, op string, timeout time.Duration, fn func(ctx context.Context) error) (*logrus.Entry, error) {
+	start := time.Now()
+	err := watchCall(rootCtx, cont.ID, op, timeout, fn)
+	olog := finishOp(logger.WithField("container_id", cont.ID), cont.ID, op, start, err)
+	if classifyError(err) == errClassTimeout {
+		hangDetected(client, cont, op, err)
+	}
+	return olog, err
+}
+
+// finishOp records the outcome of a daemon call against a container,
+// started at start, and returns entry annotated with it.
+func finishOp(entry *logrus.Entry, containerID, operation string, start time.Time, err error) *logrus.Entry {
+	results.recordOp(containerID, operation, start, err)
+	entry = entry.WithFields(logrus.Fields{
+		"operation": operation,
+		"duration":  time.Since(start),
+	})
+	if err != nil {
+		entry = entry.WithError(err).WithField("error_class", classifyError(err))
+	}
+	return entry
 }
 
 func logStatsForContainers(ctx context.Context, out io.Writer, client *docker.Client, containers ...*docker.Container) {
-	statsChan := make(chan *docker.Stats)
+	statsChan := make(chan statsRecord, statsBufferSize)
+
+	enc := json.NewEncoder(out)
 
 	// stream stats from all containers until they stop.
 	for x := range containers {
-		id := containers[x].ID
+		cont := containers[x]
+		id := cont.ID
 
 		contStats := make(chan *docker.Stats)
 
-		go client.Stats(docker.StatsOptions{
-			Context: ctx,
-			ID:      id,
-			Stats:   contStats,
+		goSafe(func() {
+			if statsPollInterval > 0 {
+				pollStats(ctx, client, cont, contStats, statsPollInterval)
+				return
+			}
+			client.Stats(docker.StatsOptions{
+				Context: ctx,
+				ID:      id,
+				Stats:   contStats,
+				Stream:  true,
+			})
 		})
 		// combine stats logging for individual containers
-		go func() {
-
-			log.Printf("Listening for stats for container %q", id)
+		goSafe(func() {
+			clog := logger.WithField("container_id", id)
+			clog.Info("Listening for stats for container")
+			sampler := statsSampler{
+				every:           statsLogEvery,
+				memoryThreshold: statsLogMemoryThreshold,
+				pidsThreshold:   statsLogPidsThreshold,
+			}
+			samples := 0
 			for {
 				select {
 				case <-ctx.Done():
 					return
 				case stat, ok := <-contStats:
 					if !ok {
-						log.Printf("Container %q is no longer streaming", id)
+						clog.Info("Container is no longer streaming")
 						return
 					}
-					log.Printf("Received stat for container %q", id)
-					statsChan <- stat
+					if stat == nil {
+						continue
+					}
+					results.recordStatsSample(id)
+					if sampler.sample(stat) {
+						clog.WithFields(statsFields(stat)).Info("Received stat for container")
+					} else {
+						clog.Debug("Received stat for container")
+					}
+					dumpPayload(clog, "stats", stat)
+					samples++
+					if statsSampleEvery > 1 && (samples-1)%statsSampleEvery != 0 {
+						continue
+					}
+					sendDropOldest(statsChan, newStatsRecord(id, stat))
 				}
 			}
-		}()
+		})
 	}
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case stat := <-statsChan:
-			if stat == nil {
-				continue
+		case rec := <-statsChan:
+			if err := enc.Encode(rec); err != nil {
+				logger.WithError(err).Warn("Could not write stats sample")
 			}
-			fmt.Fprintf(out, "%#v\n", stat)
 		}
 	}
 
 }
 
+// getEngineInfo records the daemon's version and info in the results in
+// full, and returns the parts of them triage starts from. Only a failed
+// version call is an error, a daemon that can't answer /info is
+// described without it.
+func getEngineInfo(client *docker.Client) (engineInfo, error) {
+	env, err := client.Version()
+	if err != nil {
+		return engineInfo{}, err
+	}
+	results.setVersion(env.Map())
+	engine := engineInfo{
+		Version:       env.Get("Version"),
+		APIVersion:    env.Get("ApiVersion"),
+		GitCommit:     env.Get("GitCommit"),
+		GoVersion:     env.Get("GoVersion"),
+		KernelVersion: env.Get("KernelVersion"),
+		BuildTime:     env.Get("BuildTime"),
+	}
+
+	ctx, cancel := context.WithTimeout(rootCtx, time.Duration(callTimeoutSecs)*time.Second)
+	defer cancel()
+	raw, err := getDaemonJSON(ctx, client, "/info")
+	if err != nil {
+		logger.WithError(err).Warn("Could not get daemon info")
+		return engine, nil
+	}
+	results.setInfo(raw)
+	var info daemonInfo
+	if err := json.Unmarshal(raw, &info); err != nil {
+		logger.WithError(err).Warn("Could not decode daemon info")
+		return engine, nil
+	}
+	engine.OperatingSystem = info.OperatingSystem
+	engine.StorageDriver = info.Driver
+	engine.CgroupDriver = info.CgroupDriver
+	engine.ContainerdCommit = info.ContainerdCommit.ID
+	engine.RuncCommit = info.RuncCommit.ID
+	engine.LogDrivers = info.Plugins.Log
+	return engine, nil
+}
+
 func buildImageOptions(name string) docker.BuildImageOptions {
-	log.Println("Building docker container for test")
+	logger.Info("Building docker container for test")
 	t := time.Now()
 	inputbuf := bytes.NewBuffer(nil)
 	tr := tar.NewWriter(inputbuf)
-	data := bytes.NewBuffer(nil)
-
-	fmt.Fprintf(data, imageDockerfile, imageSleepTimeString)
+	data := bytes.NewBufferString(renderDockerfile())
 
 	tr.WriteHeader(&tar.Header{Name: "Dockerfile", Size: int64(len(data.Bytes())), ModTime: t, AccessTime: t, ChangeTime: t})
 	tr.Write(data.Bytes())
 	tr.Close()
 	opts := docker.BuildImageOptions{
+		Context:      rootCtx,
 		Name:         name,
+		Labels:       map[string]string{toolLabel: "true", dockerfileLabel: dockerfileDigest()},
 		InputStream:  inputbuf,
-		OutputStream: os.Stdout,
+		OutputStream: buildOutput(),
+	}
+	if contextDir != "" {
+		opts.InputStream = nil
+		opts.ContextDir = contextDir
+		opts.Dockerfile = contextDockerfile
 	}
 	return opts
 }
 
-func createContainer(client *docker.Client) (*docker.Container, error) {
+// buildOutput is where the daemon's build progress is written. It is
+// only interesting when debugging the image itself.
+func buildOutput() io.Writer {
+	if verbose {
+		return os.Stdout
+	}
+	return ioutil.Discard
+}
+
+// dockerfileDigest identifies the Dockerfile the test image is built
+// from, and its build context if there is one, so that an existing
+// image can be checked to match it.
+func dockerfileDigest() string {
+	h := sha256.New()
+	io.WriteString(h, renderDockerfile())
+	if contextDir != "" {
+		hashContext(h, contextDir)
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil))
+}
+
+// selectImage picks the test image and its Dockerfile from the
+// image, healthchecks, dockerfile and context-dir flags.
+func selectImage() error {
+	if imageRef != "" {
+		if dockerfilePath != "" || contextDir != "" {
+			return fmt.Errorf("--image can't be combined with --dockerfile or --context-dir")
+		}
+		imageName = imageRef
+		logger.WithField("image", imageRef).Info("Using prebuilt image")
+		return nil
+	}
+	if contextDir != "" {
+		if dockerfilePath == "" {
+			dockerfilePath = filepath.Join(contextDir, "Dockerfile")
+		}
+		rel, err := filepath.Rel(contextDir, dockerfilePath)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("Dockerfile %s is not in the build context %s", dockerfilePath, contextDir)
+		}
+		contextDockerfile = filepath.ToSlash(rel)
+	}
+	if dockerfilePath != "" {
+		data, err := ioutil.ReadFile(dockerfilePath)
+		if err != nil {
+			return errors.Wrap(err, "could not read Dockerfile")
+		}
+		imageName = customImageName
+		logger.WithField("dockerfile", dockerfilePath).Info("Using custom Dockerfile")
+		customDockerfile = string(data)
+		return nil
+	}
+	if useHealthchecks && !healthcheckAtCreate {
+		imageName = "docker-poke:healthchecks"
+		logger.Info("Using Dockerfile with healthchecks")
+		imageDockerfile = healthcheckDockerfile
+	} else {
+		imageName = "docker-poke:no-healthchecks"
+		if useHealthchecks {
+			logger.Info("Using Dockerfile WITHOUT healtchecks, setting them on the containers")
+		} else {
+			logger.Info("Using Dockerfile WITHOUT healtchecks")
+		}
+		imageDockerfile = noHealthcheckdockerfile
+	}
+	return nil
+}
+
+// renderDockerfile returns the Dockerfile the test image is built from.
+func renderDockerfile() string {
+	if customDockerfile != "" {
+		return customDockerfile
+	}
+	return fmt.Sprintf(imageDockerfile, baseImage, imageSleepTimeString, healthcheckInstruction())
+}
+
+// containerConfig is the config every test container is created with.
+func containerConfig(control bool) *docker.Config {
+	config := &docker.Config{
+		Image:       imageName,
+		Labels:      map[string]string{runLabel: runID},
+		StopSignal:  containerStopSignal,
+		StopTimeout: int(containerStopTimeout),
+		Tty:         withTTY,
+	}
+	switch scenario {
+	case scenarioExitRestart:
+		config.Cmd = exitCommand()
+	case scenarioHTTPProbe:
+		config.Cmd = workloadCommand()
+		config.ExposedPorts = map[docker.Port]struct{}{workloadPort: {}}
+	}
+	switch {
+	case control:
+		config.Healthcheck = disabledHealthConfig()
+	case useHealthchecks && healthcheckAtCreate:
+		config.Healthcheck = healthConfig()
+	}
+	return config
+}
+
+// hostConfig is the host config every test container is created with,
+// nil if it doesn't need one.
+func hostConfig() *docker.HostConfig {
+	hc := &docker.HostConfig{
+		AutoRemove: scenario == scenarioAutoRemove,
+		Init:       withInit,
+	}
+	if restartPolicy.Name != "no" {
+		hc.RestartPolicy = restartPolicy
+	}
+	if logDriver != "" {
+		hc.LogConfig = docker.LogConfig{Type: logDriver, Config: logOpts}
+	}
+	hc.NetworkMode = networkMode
+	if scenario == scenarioHTTPProbe {
+		hc.PortBindings = map[docker.Port][]docker.PortBinding{
+			workloadPort: {{HostIP: "127.0.0.1"}},
+		}
+	}
+	hc.Tmpfs = tmpfs
+	hc.Binds = binds
+	limitResources(hc)
+	if reflect.DeepEqual(hc, &docker.HostConfig{}) {
+		return nil
+	}
+	return hc
+}
+
+// createContainer creates a test container, or a control container
+// with its healthcheck disabled.
+func createContainer(client *docker.Client, control bool, cohort string) (*docker.Container, error) {
+	hc := hostConfig()
+	var volume string
+	if volumeOps {
+		var err error
+		volume, err = createVolume(client)
+		if classifyError(err) == errClassTimeout {
+			exit(2, "FAIL: creating a volume hung")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hc == nil {
+			hc = &docker.HostConfig{}
+		}
+		hc.Binds = append(append([]string(nil), hc.Binds...), volume+":"+volumeMountPath)
+	}
 	container, err := client.CreateContainer(docker.CreateContainerOptions{
-		Config: &docker.Config{
-			Image: imageName,
-		},
+		Context:    rootCtx,
+		Config:     containerConfig(control),
+		HostConfig: hc,
 	})
+	if err == nil {
+		dumpPayload(logger.WithField("container_id", container.ID), "create", container)
+		results.addContainer(container.ID, control, cohort)
+		registerContainerTeardown(client, container)
+		if volume != "" {
+			setVolume(container.ID, volume)
+		}
+	}
 
 	return container, err
 }
@@ -277,22 +1367,103 @@ func logEvents(ctx context.Context, out io.Writer, events <-chan *docker.APIEven
 	}
 }
 
+// logFile opens name in the run's directory as a rotating log.
 func logFile(name string) io.WriteCloser {
-	stamp := progT.Format(time.RFC3339)
+	path := runPath(name)
 
-	statsoutName := fmt.Sprintf("%s-%s", name, stamp)
+	logger.Infof("logging %q to %q", name, path)
 
-	log.Printf("logging %q to %q", name, statsoutName)
-
-	outfile, err := os.OpenFile(statsoutName, os.O_CREATE|os.O_WRONLY, 0640)
+	outfile, err := openRotatingFile(path, artifactRotation)
 	failOnError(err)
 
 	return outfile
 }
 
+// newRunID returns a short identifier that is unique enough to tell
+// concurrent invocations on the same host apart.
+func newRunID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return time.Now().Format("20060102T150405") + "-" + hex.EncodeToString(b)
+}
+
+// dumpPayload logs the full API payload v as JSON in verbose mode.
+func dumpPayload(entry *logrus.Entry, what string, v interface{}) {
+	if !verbose {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		entry.WithError(err).Debugf("Could not encode %s payload", what)
+		return
+	}
+	entry.WithField("payload", string(data)).Debugf("%s payload", what)
+}
+
+// exit reports the final verdict of the run and exits with code.
+func exit(code int, verdict string) {
+	exitMu.Lock()
+	atomic.StoreInt32(&exiting, 1)
+	runTeardown()
+	if collectDaemonLogs {
+		collectDaemonSide()
+	}
+	// Neither a pass nor a hang means anything if the daemon was not
+	// the same one throughout.
+	if reason := results.invalid(); reason != "" && (code == 0 || code == 2) {
+		code, verdict = 3, fmt.Sprintf("INCONCLUSIVE: %s (was %s)", reason, verdict)
+	}
+	reportVerdict(code, verdict)
+	os.Exit(code)
+}
+
+// reportVerdict records the final verdict of the run in the report and
+// the history, writes the artifacts that summarize the run, and prints
+// the run summary. In quiet mode only the verdict is printed.
+func reportVerdict(code int, verdict string) {
+	path, err := results.finish(code, verdict)
+	if err != nil {
+		logger.WithError(err).Error("Could not write results")
+	} else {
+		logger.WithField("path", path).Info("Wrote results")
+	}
+	if err := appendHistory(results); err != nil {
+		logger.WithError(err).Error("Could not add run to history")
+	}
+	if issueReport {
+		if path, err := writeIssueReport(results); err != nil {
+			logger.WithError(err).Error("Could not write issue report")
+		} else {
+			logger.WithField("path", path).Info("Wrote issue report")
+		}
+	}
+	if bundleArtifacts {
+		if path, err := writeBundle(); err != nil {
+			logger.WithError(err).Error("Could not write artifact bundle")
+		} else {
+			logger.WithField("path", path).Info("Wrote artifact bundle")
+			if s3Bucket != "" {
+				if u, err := uploadBundle(path); err != nil {
+					logger.WithError(err).Error("Could not upload artifact bundle")
+				} else {
+					logger.WithField("url", u).Info("Uploaded artifact bundle")
+				}
+			}
+		}
+	}
+
+	if quiet {
+		fmt.Printf("%s (run %s, exit %d)\n", verdict, runID, code)
+		return
+	}
+	logger.WithField("exit_code", code).Info(verdict)
+	results.printSummary(os.Stdout)
+}
+
 func failOnError(err error) {
 	if err != nil {
-		log.Printf("%s", err)
-		os.Exit(1)
+		exit(1, "ERROR: "+err.Error())
 	}
 }
@@ -0,0 +1,87 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+var (
+	// tmpfsSpec holds the tmpfs mounts of the containers, semicolon
+	// separated as their options are comma separated, eg.
+	// /data:size=16m,noexec.
+	tmpfsSpec string
+	tmpfs     map[string]string
+	// bindsSpec holds the bind mounts of the containers, comma
+	// separated, as docker run's --volume takes them, eg.
+	// /var/tmp/repro:/data:ro.
+	bindsSpec string
+	binds     []string
+)
+
+// parseMounts parses and checks the tmpfs and bind mounts.
+func parseMounts() error {
+	for _, spec := range strings.Split(tmpfsSpec, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		target, opts := spec, ""
+		if i := strings.Index(spec, ":"); i >= 0 {
+			target, opts = spec[:i], spec[i+1:]
+		}
+		if !path.IsAbs(target) {
+			return fmt.Errorf("tmpfs mount %q isn't at an absolute path", spec)
+		}
+		if tmpfs == nil {
+			tmpfs = make(map[string]string)
+		}
+		tmpfs[target] = opts
+	}
+	for _, spec := range strings.Split(bindsSpec, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		parts := strings.Split(spec, ":")
+		if len(parts) < 2 || len(parts) > 3 || !path.IsAbs(parts[0]) || !path.IsAbs(parts[1]) {
+			return fmt.Errorf("bind mount %q isn't host-path:container-path[:options]", spec)
+		}
+		binds = append(binds, spec)
+	}
+	return nil
+}
+
+// mountPath returns where the first mount is in the containers, the
+// one the mount-io healthcheck writes to, or "" without mounts. Their
+// volumes come last.
+func mountPath() string {
+	if len(binds) != 0 {
+		return strings.Split(binds[0], ":")[1]
+	}
+	var first string
+	for target := range tmpfs {
+		if first == "" || target < first {
+			first = target
+		}
+	}
+	if first == "" && volumeOps {
+		return volumeMountPath
+	}
+	return first
+}
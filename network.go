@@ -0,0 +1,151 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/sirupsen/logrus"
+)
+
+// networkMode is the network mode of the containers: bridge, host,
+// none, container:<name|id> or the name of a network, the daemon's
+// default bridge if empty. Setting up and tearing down the containers'
+// network namespaces may play a part in the hang.
+var networkMode string
+
+// networkChurnInterval is how often the containers are connected to and
+// disconnected from a network of the run's own, if at all. Network
+// calls take libnetwork's locks, which are a common source of hangs.
+var networkChurnInterval time.Duration
+
+// checkNetworkMode checks networkMode names what it joins.
+func checkNetworkMode(mode string) error {
+	if strings.HasPrefix(mode, "container:") && strings.TrimPrefix(mode, "container:") == "" {
+		return fmt.Errorf("network mode %q doesn't name the container to join", mode)
+	}
+	if networkChurnInterval > 0 && (mode == "host" || mode == "none" || strings.HasPrefix(mode, "container:")) {
+		return fmt.Errorf("containers in network mode %q can't be connected to another network", mode)
+	}
+	return nil
+}
+
+// resourceCall makes a call against a network or volume rather than a
+// container, bounded by the call timeout. A hang is logged and recorded
+// like a container's, but has no container to mark affected.
+func resourceCall(op string, fn func(ctx context.Context) error) error {
+	start := time.Now()
+	err := watchCall(rootCtx, "", op, time.Duration(callTimeoutSecs)*time.Second, fn)
+	nlog := logger.WithFields(logrus.Fields{
+		"operation": op,
+		"duration":  time.Since(start),
+	})
+	if classifyError(err) == errClassTimeout {
+		nlog.WithError(err).Error("Hang detected")
+	}
+	return err
+}
+
+// createRunNetwork creates the network the containers are connected to
+// and disconnected from, labeled with the run's ID, and has it removed
+// on teardown if the run doesn't get to it.
+func createRunNetwork(client *docker.Client) (*docker.Network, error) {
+	var network *docker.Network
+	err := resourceCall("network-create", func(ctx context.Context) (err error) {
+		network, err = client.CreateNetwork(docker.CreateNetworkOptions{
+			Context: ctx,
+			Name:    "health-stats-repro-" + runID,
+			Driver:  "bridge",
+			Labels:  map[string]string{runLabel: runID},
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	logger.WithField("network_id", network.ID).Info("Created network")
+	onTeardown(phaseNetworksVolumes, "remove network "+network.ID, func(ctx context.Context) error {
+		err := client.RemoveNetwork(network.ID)
+		if _, ok := err.(*docker.NoSuchNetwork); ok {
+			return nil
+		}
+		return err
+	})
+	return network, nil
+}
+
+// removeRunNetwork removes the run's network once its containers are
+// stopped.
+func removeRunNetwork(client *docker.Client, network *docker.Network) error {
+	err := resourceCall("network-remove", func(context.Context) error {
+		return client.RemoveNetwork(network.ID)
+	})
+	nlog := logger.WithField("network_id", network.ID)
+	if err != nil {
+		nlog.WithError(err).Error("Could not remove network")
+		return err
+	}
+	nlog.Info("Removed network")
+	return nil
+}
+
+// churnNetwork connects conts to network one after the other, every
+// interval, and disconnects each again an interval later, until ctx is
+// done. Both calls are bounded by the call timeout and a container that
+// hung is left alone.
+func churnNetwork(ctx context.Context, client *docker.Client, conts []*docker.Container, network *docker.Network, interval time.Duration) {
+	callTimeout := time.Duration(callTimeoutSecs) * time.Second
+	for {
+		for _, cont := range conts {
+			clog := logger.WithFields(logrus.Fields{
+				"container_id": cont.ID,
+				"network_id":   network.ID,
+			})
+			for _, op := range []string{"network-connect", "network-disconnect"} {
+				if err := sleepCtx(ctx, interval); err != nil {
+					return
+				}
+				if isAffected(cont.ID) {
+					break
+				}
+				start := time.Now()
+				err := watchCall(ctx, cont.ID, op, callTimeout, func(ctx context.Context) error {
+					opts := docker.NetworkConnectionOptions{Context: ctx, Container: cont.ID}
+					if op == "network-connect" {
+						return client.ConnectNetwork(network.ID, opts)
+					}
+					return client.DisconnectNetwork(network.ID, opts)
+				})
+				if ctx.Err() != nil {
+					return
+				}
+				olog := finishOp(clog, cont.ID, op, start, err)
+				if classifyError(err) == errClassTimeout {
+					hangDetected(client, cont, op, err)
+				}
+				if err != nil {
+					olog.Warn("Could not churn container network")
+					break
+				}
+				olog.Debug("Churned container network")
+			}
+		}
+	}
+}
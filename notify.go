@@ -0,0 +1,112 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Notification payload formats.
+const (
+	notifyJSON  = "json"
+	notifySlack = "slack"
+)
+
+const notifyTimeout = 10 * time.Second
+
+var (
+	notifyURL    string
+	notifyFormat string
+
+	notified sync.Once
+)
+
+// hangNotification is the JSON payload posted when a hang is detected.
+type hangNotification struct {
+	Event       string    `json:"event"`
+	RunID       string    `json:"run_id"`
+	Host        string    `json:"host"`
+	Time        time.Time `json:"time"`
+	ContainerID string    `json:"container_id"`
+	Operation   string    `json:"operation"`
+	Error       string    `json:"error"`
+	RunDir      string    `json:"run_dir"`
+}
+
+// notifyOnDetection posts to --notify-url the first time a hang is
+// detected, so that someone can get onto the host while the daemon is
+// still wedged.
+func notifyOnDetection(containerID, op string, err error) {
+	if notifyURL == "" {
+		return
+	}
+	notified.Do(func() {
+		host, _ := os.Hostname()
+		n := hangNotification{
+			Event:       "hang_detected",
+			RunID:       runID,
+			Host:        host,
+			Time:        time.Now(),
+			ContainerID: containerID,
+			Operation:   op,
+			Error:       err.Error(),
+			RunDir:      runDir(),
+		}
+		if err := postNotification(n); err != nil {
+			logger.WithError(err).Warn("Could not send hang notification")
+			return
+		}
+		logger.Info("Sent hang notification")
+	})
+}
+
+func postNotification(n hangNotification) error {
+	var payload interface{} = n
+	if notifyFormat == notifySlack {
+		payload = map[string]string{
+			"text": fmt.Sprintf(":rotating_light: %s of container `%s` hung on `%s` (run `%s`, artifacts in `%s`): %s",
+				n.Operation, shortID(n.ContainerID), n.Host, n.RunID, n.RunDir, n.Error),
+		}
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	req, err := http.NewRequest("POST", notifyURL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("POST %s: %s", notifyURL, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// opsSpec is the --ops flag, the calls made against each container once
+// the run is over, see parseOps.
+var opsSpec string
+
+// checkOpOrder is the order the checks are made in, whatever order they
+// are given in.
+var checkOpOrder = []string{"top", "logs", "kill", "wait", "inspect", "remove"}
+
+// checkOp is a call made against each container once the run is over.
+type checkOp struct {
+	name    string
+	timeout time.Duration
+}
+
+// checkOps are the run's checks, as parsed from --ops.
+var checkOps []checkOp
+
+// defaultOps returns the checks made without --ops: the container is
+// killed and waited on with --stop-containers, inspected, and removed
+// with --remove-containers.
+func defaultOps() string {
+	ops := []string{}
+	if stopContainers {
+		ops = append(ops, "kill", "wait")
+	}
+	ops = append(ops, "inspect")
+	if removeContainers {
+		ops = append(ops, "remove")
+	}
+	return strings.Join(ops, ",")
+}
+
+// parseOps parses a comma separated list of checks, each optionally
+// with a timeout of its own, as in inspect,kill:30s. Checks without one
+// get timeout.
+func parseOps(spec string, timeout time.Duration) ([]checkOp, error) {
+	given := map[string]time.Duration{}
+	for _, op := range strings.Split(spec, ",") {
+		op = strings.TrimSpace(op)
+		if op == "" {
+			continue
+		}
+		parts := strings.SplitN(op, ":", 2)
+		d := timeout
+		if len(parts) == 2 {
+			var err error
+			if d, err = time.ParseDuration(parts[1]); err != nil || d <= 0 {
+				return nil, fmt.Errorf("bad timeout of check %q", op)
+			}
+		}
+		if !validCheckOp(parts[0]) {
+			return nil, fmt.Errorf("unknown check %q, checks are %s", parts[0], strings.Join(checkOpOrder, ", "))
+		}
+		given[parts[0]] = d
+	}
+	if len(given) == 0 {
+		return nil, fmt.Errorf("no checks given")
+	}
+	var ops []checkOp
+	for _, name := range checkOpOrder {
+		if d, ok := given[name]; ok {
+			ops = append(ops, checkOp{name: name, timeout: d})
+		}
+	}
+	return ops, nil
+}
+
+func validCheckOp(name string) bool {
+	for _, op := range checkOpOrder {
+		if op == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hasCheckOp returns whether the run makes the check name.
+func hasCheckOp(name string) bool {
+	for _, op := range checkOps {
+		if op.name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,253 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// pprofProfile is one profile fetched from a daemon's /debug/pprof.
+type pprofProfile struct {
+	name  string
+	query string
+	ext   string
+}
+
+var dockerdProfiles = []pprofProfile{
+	{name: "goroutine", query: "debug=2", ext: "txt"},
+	{name: "heap", ext: "pprof"},
+	{name: "mutex", ext: "pprof"},
+}
+
+// containerdProfiles include a short execution trace, which shows what
+// containerd's goroutines are blocked on at the checkpoint.
+var containerdProfiles = []pprofProfile{
+	{name: "goroutine", query: "debug=2", ext: "txt"},
+	{name: "trace", query: "seconds=2", ext: "trace"},
+}
+
+// pprofSource is a daemon whose pprof endpoints are collected at each
+// checkpoint of the run.
+type pprofSource struct {
+	name     string
+	client   *http.Client
+	base     string
+	profiles []pprofProfile
+}
+
+var (
+	daemonPprof bool
+	// containerdDebugSocket is the socket containerd serves its debug
+	// endpoints on, empty to not collect them.
+	containerdDebugSocket string
+
+	pprofMu      sync.Mutex
+	pprofSources []*pprofSource
+
+	pprofOnHang sync.Once
+)
+
+// dockerdPprofSource returns a source for dockerd's pprof endpoints,
+// which it serves on its API socket only when it runs in debug mode.
+func dockerdPprofSource(ctx context.Context, client *docker.Client) (*pprofSource, error) {
+	base, err := daemonURL(client, "/debug/pprof")
+	if err != nil {
+		return nil, err
+	}
+	src := &pprofSource{
+		name:     "dockerd",
+		client:   client.HTTPClient,
+		base:     base,
+		profiles: dockerdProfiles,
+	}
+	if err := src.probe(ctx); err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+// containerdPprofSource returns a source for the pprof endpoints on
+// containerd's debug socket.
+func containerdPprofSource(ctx context.Context, socket string) (*pprofSource, error) {
+	src := &pprofSource{
+		name: "containerd",
+		client: &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}},
+		base:     "http://containerd/debug/pprof",
+		profiles: containerdProfiles,
+	}
+	if err := src.probe(ctx); err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+func (s *pprofSource) probe(ctx context.Context) error {
+	resp, err := s.get(ctx, s.base+"/")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *pprofSource) get(ctx context.Context, u string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s has no pprof endpoints, is debug enabled?", s.name)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	return resp, nil
+}
+
+// collect saves each of the source's profiles for checkpoint. It carries
+// on past a failed profile and returns the first error.
+func (s *pprofSource) collect(ctx context.Context, checkpoint string) error {
+	var first error
+	for _, p := range s.profiles {
+		path, err := s.save(ctx, checkpoint, p)
+		plog := logger.WithFields(logrus.Fields{
+			"pprof_source":  s.name,
+			"pprof_profile": p.name,
+			"checkpoint":    checkpoint,
+		})
+		if err != nil {
+			plog.WithError(err).Warn("Could not collect profile")
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		plog.WithField("path", path).Debug("Collected profile")
+	}
+	return first
+}
+
+func (s *pprofSource) save(ctx context.Context, checkpoint string, p pprofProfile) (string, error) {
+	u := s.base + "/" + p.name
+	if p.query != "" {
+		u += "?" + p.query
+	}
+	resp, err := s.get(ctx, u)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	name := runPath("daemon", fmt.Sprintf("%s-%s-%s.%s", s.name, checkpoint, p.name, p.ext))
+	f, err := os.Create(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		return "", errors.Wrapf(err, "read %s", u)
+	}
+	return name, nil
+}
+
+func addPprofSource(s *pprofSource) {
+	pprofMu.Lock()
+	defer pprofMu.Unlock()
+	pprofSources = append(pprofSources, s)
+}
+
+// collectPprof saves the profiles of every source for checkpoint.
+func collectPprof(ctx context.Context, checkpoint string) error {
+	pprofMu.Lock()
+	sources := pprofSources
+	pprofMu.Unlock()
+
+	var failed []string
+	for _, s := range sources {
+		if err := s.collect(ctx, checkpoint); err != nil {
+			failed = append(failed, s.name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("incomplete %s profiles from %s", checkpoint, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// collectPprofOnDetection saves profiles the first time a hang is
+// detected.
+func collectPprofOnDetection() {
+	pprofOnHang.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(callTimeoutSecs)*time.Second)
+		defer cancel()
+		collectPprof(ctx, "hang")
+	})
+}
+
+// startPprof sets up dockerd and containerd pprof collection, saving
+// the start profiles now and the end profiles at teardown, before any
+// container is killed.
+func startPprof(client *docker.Client) {
+	ctx, cancel := context.WithTimeout(rootCtx, time.Duration(callTimeoutSecs)*time.Second)
+	defer cancel()
+	if daemonPprof {
+		src, err := dockerdPprofSource(ctx, client)
+		if err != nil {
+			logger.WithError(err).Info("Not collecting dockerd profiles")
+		} else {
+			addPprofSource(src)
+		}
+	}
+	if containerdDebugSocket != "" {
+		src, err := containerdPprofSource(ctx, containerdDebugSocket)
+		if err != nil {
+			logger.WithError(err).Warn("Not collecting containerd profiles")
+		} else {
+			addPprofSource(src)
+		}
+	}
+	if len(pprofSources) == 0 {
+		return
+	}
+
+	collectPprof(ctx, "start")
+	onTeardown(phaseStreams, "pprof", func(ctx context.Context) error {
+		return collectPprof(ctx, "end")
+	})
+}
@@ -0,0 +1,66 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// inspectQPS is the rate the containers are inspected at while the run
+// waits, on top of the checks at its end.
+var inspectQPS float64
+
+// inspectLoad inspects conts round robin at qps until ctx is done. Each
+// inspect is bounded by the call timeout like the checks are, and
+// containers that hung aren't inspected again.
+func inspectLoad(ctx context.Context, client *docker.Client, conts []*docker.Container, qps float64) {
+	callTimeout := time.Duration(callTimeoutSecs) * time.Second
+	tick := time.NewTicker(time.Duration(float64(time.Second) / qps))
+	defer tick.Stop()
+	for i := 0; ; i++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		cont := conts[i%len(conts)]
+		if isAffected(cont.ID) {
+			continue
+		}
+		goSafe(func() {
+			clog := logger.WithField("container_id", cont.ID)
+			start := time.Now()
+			err := watchCall(ctx, cont.ID, "inspect", callTimeout, func(ctx context.Context) error {
+				insp, err := client.InspectContainerWithContext(cont.ID, ctx)
+				noteExecIDs(insp)
+				return err
+			})
+			if ctx.Err() != nil {
+				return
+			}
+			olog := finishOp(clog, cont.ID, "inspect", start, err)
+			if classifyError(err) == errClassTimeout {
+				hangDetected(client, cont, "inspect", err)
+			}
+			if err != nil {
+				olog.Warn("Background inspect failed")
+			}
+		})
+	}
+}
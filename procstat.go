@@ -0,0 +1,148 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is USER_HZ, which is 100 on every Linux platform Docker
+// runs on.
+const clockTicks = 100
+
+var daemonProcInterval time.Duration
+
+// procSample is the resource usage of a daemon process at one point of
+// the run.
+type procSample struct {
+	Time       time.Time `json:"time"`
+	Process    string    `json:"process"`
+	PID        int       `json:"pid"`
+	RSSBytes   uint64    `json:"rss_bytes"`
+	CPUPercent float64   `json:"cpu_percent"`
+	Threads    int       `json:"threads"`
+}
+
+// daemonProcesses are the local daemon processes that are sampled, by
+// the names they may run under.
+var daemonProcesses = [][]string{
+	{"dockerd"},
+	{"containerd", "docker-containerd"},
+}
+
+// sampleDaemonProcs samples the local daemon processes every interval
+// until ctx is done. Processes are looked up again on each sample, so
+// that one that restarts is followed.
+func sampleDaemonProcs(ctx context.Context, interval time.Duration) {
+	// CPU time is per process, so the last sample is kept per pid.
+	type cpuTime struct {
+		at    time.Time
+		ticks uint64
+	}
+	last := map[int]cpuTime{}
+
+	for {
+		for _, names := range daemonProcesses {
+			pid, name, err := findAnyProcess(names)
+			if err != nil {
+				continue
+			}
+			now := time.Now()
+			ticks, err := processCPUTicks(pid)
+			if err != nil {
+				continue
+			}
+			sample := procSample{Time: now, Process: name, PID: pid}
+			sample.RSSBytes, sample.Threads, err = processStatus(pid)
+			if err != nil {
+				continue
+			}
+			if prev, ok := last[pid]; ok && now.After(prev.at) {
+				used := float64(ticks-prev.ticks) / clockTicks
+				sample.CPUPercent = 100 * used / now.Sub(prev.at).Seconds()
+			}
+			last[pid] = cpuTime{at: now, ticks: ticks}
+			results.addProcSample(sample)
+		}
+		if sleepCtx(ctx, interval) != nil {
+			return
+		}
+	}
+}
+
+func findAnyProcess(names []string) (int, string, error) {
+	for _, name := range names {
+		if pid, err := findProcess(name); err == nil {
+			return pid, name, nil
+		}
+	}
+	return 0, "", fmt.Errorf("no %s process found", strings.Join(names, " or "))
+}
+
+// processCPUTicks returns the user and system CPU time pid has used, in
+// clock ticks.
+func processCPUTicks(pid int) (uint64, error) {
+	fields, err := processStatFields(pid)
+	if err != nil {
+		return 0, err
+	}
+	// utime and stime are fields 14 and 15.
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return utime + stime, nil
+}
+
+// processStatus returns the resident set size and thread count of pid.
+func processStatus(pid int) (rss uint64, threads int, err error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "VmRSS:":
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, 0, err
+			}
+			rss = kb << 10
+		case "Threads:":
+			threads, err = strconv.Atoi(fields[1])
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+	return rss, threads, s.Err()
+}
@@ -0,0 +1,115 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// rampLevel is the pressure a step of a ramp puts on the daemon.
+type rampLevel struct {
+	Containers          int
+	HealthcheckInterval time.Duration
+	InspectQPS          float64
+}
+
+func (l rampLevel) String() string {
+	return fmt.Sprintf("--containers=%d --healthcheck-interval=%s --inspect-qps=%g", l.Containers, l.HealthcheckInterval, l.InspectQPS)
+}
+
+// next doubles the pressure of every knob that isn't at its ceiling
+// yet. ok is false once all of them are.
+func (l rampLevel) next(ceiling rampLevel) (rampLevel, bool) {
+	n := l
+	if n.Containers < ceiling.Containers {
+		n.Containers *= 2
+		if n.Containers > ceiling.Containers {
+			n.Containers = ceiling.Containers
+		}
+	}
+	if n.HealthcheckInterval > ceiling.HealthcheckInterval {
+		n.HealthcheckInterval /= 2
+		if n.HealthcheckInterval < ceiling.HealthcheckInterval {
+			n.HealthcheckInterval = ceiling.HealthcheckInterval
+		}
+	}
+	if n.InspectQPS < ceiling.InspectQPS {
+		n.InspectQPS *= 2
+		if n.InspectQPS > ceiling.InspectQPS {
+			n.InspectQPS = ceiling.InspectQPS
+		}
+	}
+	return n, n != l
+}
+
+// rampCommand raises the pressure on the daemon in steps, with runs of
+// the run flags given after its own at each, until a run reproduces the
+// hang or every knob is at its ceiling. The first step that reproduced
+// is the least pressure found to do so.
+func rampCommand(args []string) {
+	fs := flag.NewFlagSet("ramp", flag.ExitOnError)
+	level := rampLevel{}
+	ceiling := rampLevel{}
+	fs.IntVar(&level.Containers, "containers", 2, "Containers of the first step")
+	fs.IntVar(&ceiling.Containers, "max-containers", 64, "Most containers to ramp up to")
+	fs.DurationVar(&level.HealthcheckInterval, "healthcheck-interval", time.Second, "Healthcheck interval of the first step")
+	fs.DurationVar(&ceiling.HealthcheckInterval, "min-healthcheck-interval", 100*time.Millisecond, "Shortest healthcheck interval to ramp down to")
+	fs.Float64Var(&level.InspectQPS, "inspect-qps", 1, "Inspect rate of the first step")
+	fs.Float64Var(&ceiling.InspectQPS, "max-inspect-qps", 100, "Highest inspect rate to ramp up to")
+	runsPerStep := fs.Int("runs-per-step", 1, "Runs to make at each step before raising the pressure")
+	fs.Parse(args)
+
+	registerFlags()
+	logger = logrus.NewEntry(logrus.StandardLogger())
+	flag.CommandLine.Parse(fs.Args())
+	runFlags := map[string]string{}
+	flag.Visit(func(f *flag.Flag) {
+		runFlags[f.Name] = f.Value.String()
+	})
+
+	m, err := newRunManager(0)
+	if err != nil {
+		logger.WithError(err).Fatal("Could not find own executable")
+	}
+	for step := 1; ; step++ {
+		runFlags["containers"] = fmt.Sprint(level.Containers)
+		runFlags["healthcheck-interval"] = level.HealthcheckInterval.String()
+		runFlags["inspect-qps"] = fmt.Sprint(level.InspectQPS)
+		for i := 0; i < *runsPerStep; i++ {
+			run, err := m.start(runFlags, false)
+			if err != nil {
+				logger.WithError(err).Fatal("Could not start run")
+			}
+			<-run.done
+			o := outcome(*run.ExitCode)
+			fmt.Printf("Step %d run %s: %s with %s\n", step, run.RunID, o, level)
+			if o == outcomeFail {
+				fmt.Printf("Reproduced at step %d with %s\n", step, level)
+				return
+			}
+		}
+
+		var ok bool
+		if level, ok = level.next(ceiling); !ok {
+			fmt.Printf("No repro up to %s\n", ceiling)
+			return
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/pkg/errors"
+)
+
+// defaultRegistry is the key Docker Hub credentials are stored under.
+const defaultRegistry = "https://index.docker.io/v1/"
+
+var imageRef string
+
+// pullImage pulls the prebuilt test image ref, authenticating with the
+// credentials the docker CLI would use for its registry.
+func pullImage(client *docker.Client, ref string) error {
+	opts := docker.PullImageOptions{
+		Repository:   ref,
+		OutputStream: buildOutput(),
+		Context:      rootCtx,
+	}
+	if !strings.Contains(ref, "@") {
+		opts.Repository, opts.Tag = docker.ParseRepositoryTag(ref)
+		if opts.Tag == "" {
+			opts.Tag = "latest"
+		}
+	}
+
+	registry := registryOf(ref)
+	auth, err := registryAuth(registry)
+	if err != nil {
+		logger.WithError(err).WithField("registry", registry).Warn("Could not look up registry credentials, pulling anonymously")
+	}
+	logger.WithField("image", ref).Info("Pulling image")
+	if err := client.PullImage(opts, auth); err != nil {
+		return errors.Wrapf(err, "could not pull image %s", ref)
+	}
+	return requireImage(client, ref)
+}
+
+// registryOf returns the registry ref is pulled from, as it is keyed in
+// the docker CLI's configuration.
+func registryOf(ref string) string {
+	i := strings.Index(ref, "/")
+	if i < 0 {
+		return defaultRegistry
+	}
+	host := ref[:i]
+	if !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return defaultRegistry
+	}
+	return host
+}
+
+// dockerCLIConfig is the part of the docker CLI's config.json that says
+// where credentials are kept.
+type dockerCLIConfig struct {
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+func dockerCLIConfigPath() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".docker", "config.json")
+}
+
+// registryAuth returns the credentials for registry from the docker
+// CLI's config.json, asking its credential helper if it has one, as
+// docker-credential-ecr-login does for ECR. No configuration means no
+// credentials.
+func registryAuth(registry string) (docker.AuthConfiguration, error) {
+	data, err := ioutil.ReadFile(dockerCLIConfigPath())
+	if os.IsNotExist(err) {
+		return docker.AuthConfiguration{}, nil
+	} else if err != nil {
+		return docker.AuthConfiguration{}, err
+	}
+
+	var config dockerCLIConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return docker.AuthConfiguration{}, errors.Wrap(err, "could not parse docker config")
+	}
+	helper := config.CredHelpers[registry]
+	if helper == "" {
+		helper = config.CredsStore
+	}
+	if helper != "" {
+		return credentialHelperAuth(helper, registry)
+	}
+
+	auths, err := docker.NewAuthConfigurations(bytes.NewReader(data))
+	if err != nil {
+		return docker.AuthConfiguration{}, err
+	}
+	return auths.Configs[registry], nil
+}
+
+// credentialHelperAuth gets the credentials for registry from
+// docker-credential-<helper>.
+func credentialHelperAuth(helper, registry string) (docker.AuthConfiguration, error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(registry)
+	out, err := cmd.Output()
+	if err != nil {
+		return docker.AuthConfiguration{}, errors.Wrapf(err, "credential helper %s failed", helper)
+	}
+	var creds struct {
+		Username string
+		Secret   string
+	}
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return docker.AuthConfiguration{}, errors.Wrapf(err, "could not parse credentials from %s", helper)
+	}
+	return docker.AuthConfiguration{
+		Username:      creds.Username,
+		Password:      creds.Secret,
+		ServerAddress: registry,
+	}, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	units "github.com/docker/go-units"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// cpuPeriod is the CFS period a CPU limit is a quota of, the one
+// docker run's --cpus uses.
+const cpuPeriod = 100000
+
+var (
+	// containerCPUs and containerMemoryString limit the containers the
+	// way an ECS task's cpu and memory do, so healthcheck execs run
+	// throttled.
+	containerCPUs         float64
+	containerMemoryString string
+	containerMemory       int64
+
+	// containerPidsLimit caps the processes of each container. With a
+	// low limit, healthcheck execs run into it.
+	containerPidsLimit int64
+	// containerUlimitsSpec holds the ulimits of the containers, as
+	// docker run's --ulimit takes them, comma separated.
+	containerUlimitsSpec string
+	containerUlimits     []docker.ULimit
+)
+
+// parseResources parses and checks the container resource limits and
+// ulimits.
+func parseResources() error {
+	if containerCPUs < 0 {
+		return fmt.Errorf("--container-cpus can't be negative")
+	}
+	if containerMemoryString != "" {
+		var err error
+		containerMemory, err = units.RAMInBytes(containerMemoryString)
+		if err != nil {
+			return err
+		}
+		// The daemon refuses less than 4MB.
+		if containerMemory != 0 && containerMemory < 4*1024*1024 {
+			return fmt.Errorf("--container-memory %s is below the least the daemon allows, 4m", containerMemoryString)
+		}
+	}
+	if containerPidsLimit < 0 {
+		return fmt.Errorf("--container-pids-limit can't be negative")
+	}
+	if containerUlimitsSpec == "" {
+		return nil
+	}
+	for _, spec := range strings.Split(containerUlimitsSpec, ",") {
+		u, err := units.ParseUlimit(strings.TrimSpace(spec))
+		if err != nil {
+			return err
+		}
+		containerUlimits = append(containerUlimits, docker.ULimit{Name: u.Name, Soft: u.Soft, Hard: u.Hard})
+	}
+	return nil
+}
+
+// limitResources sets the container resource limits and ulimits on hc.
+func limitResources(hc *docker.HostConfig) {
+	if containerCPUs > 0 {
+		hc.CPUPeriod = cpuPeriod
+		hc.CPUQuota = int64(containerCPUs * cpuPeriod)
+	}
+	hc.Memory = containerMemory
+	hc.PidsLimit = containerPidsLimit
+	hc.Ulimits = containerUlimits
+}
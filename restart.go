@@ -0,0 +1,155 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/sirupsen/logrus"
+)
+
+// daemonIdentity identifies one lifetime of the daemon. The ID only
+// changes when the daemon's state is reset, so for a local daemon the
+// process and its start time are compared as well.
+type daemonIdentity struct {
+	ID         string `json:"id"`
+	PID        int    `json:"pid,omitempty"`
+	StartTicks uint64 `json:"start_ticks,omitempty"`
+}
+
+func (d daemonIdentity) String() string {
+	if d.PID == 0 {
+		return d.ID
+	}
+	return fmt.Sprintf("%s (pid %d)", d.ID, d.PID)
+}
+
+// daemonRestart is a change of daemon identity seen during the run.
+type daemonRestart struct {
+	Time   time.Time      `json:"time"`
+	Before daemonIdentity `json:"before"`
+	After  daemonIdentity `json:"after"`
+}
+
+var daemonRestartPoll time.Duration
+
+// restartMonitor polls the daemon's identity and records it changing.
+type restartMonitor struct {
+	client *docker.Client
+	local  bool
+
+	mu   sync.Mutex
+	last daemonIdentity
+}
+
+// watchDaemonRestarts records the daemon's identity and polls it every
+// interval until ctx is done.
+func watchDaemonRestarts(ctx context.Context, client *docker.Client, interval time.Duration) (*restartMonitor, error) {
+	m := &restartMonitor{
+		client: client,
+		local:  strings.HasPrefix(client.Endpoint(), "unix://"),
+	}
+	id, err := m.identity()
+	if err != nil {
+		return nil, err
+	}
+	m.last = id
+	results.setDaemon(id)
+	logger.WithField("daemon", id).Debug("Recorded daemon identity")
+
+	goSafe(func() {
+		for sleepCtx(ctx, interval) == nil {
+			m.check()
+		}
+	})
+	return m, nil
+}
+
+// check compares the daemon's identity with the last one seen. A daemon
+// that can't be reached is skipped, it is either wedged, which the run
+// is there to find out, or restarting, which the next check will see.
+func (m *restartMonitor) check() {
+	id, err := m.identity()
+	if err != nil {
+		logger.WithError(err).Debug("Could not check daemon identity")
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if id == m.last {
+		return
+	}
+	logger.WithFields(logrus.Fields{
+		"daemon_before": m.last,
+		"daemon_after":  id,
+	}).Error("Daemon restarted during the run, its results are not valid")
+	results.addDaemonRestart(daemonRestart{Time: time.Now(), Before: m.last, After: id})
+	m.last = id
+}
+
+func (m *restartMonitor) identity() (daemonIdentity, error) {
+	var id daemonIdentity
+	err := callWithTimeout(time.Duration(callTimeoutSecs)*time.Second, func() error {
+		info, err := m.client.Info()
+		if err != nil {
+			return err
+		}
+		id.ID = info.ID
+		return nil
+	})
+	if err != nil || !m.local {
+		return id, err
+	}
+	if pid, err := daemonPID(); err == nil {
+		id.PID = pid
+		id.StartTicks, _ = processStartTicks(pid)
+	}
+	return id, nil
+}
+
+// processStartTicks returns when pid started, in clock ticks since boot.
+func processStartTicks(pid int) (uint64, error) {
+	fields, err := processStatFields(pid)
+	if err != nil {
+		return 0, err
+	}
+	// starttime is field 22.
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// processStatFields returns the fields of /proc/<pid>/stat that follow
+// the command name, so the third field of the file is the first.
+func processStatFields(pid int) ([]string, error) {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+	// The command name may contain spaces and parentheses.
+	s := string(b)
+	fields := strings.Fields(s[strings.LastIndex(s, ")")+1:])
+	if len(fields) < 20 {
+		return nil, fmt.Errorf("short /proc/%d/stat", pid)
+	}
+	return fields, nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+var (
+	// restartPolicySpec is the restart policy of the test containers,
+	// as docker run's --restart takes it.
+	restartPolicySpec string
+	restartPolicy     docker.RestartPolicy
+
+	// exitAfter is how long the containers of the exit-restart
+	// scenario run before they exit.
+	exitAfter uint
+)
+
+// parseRestartPolicy parses a restart policy: no, always,
+// unless-stopped or on-failure with an optional maximum retry count,
+// eg. on-failure:3.
+func parseRestartPolicy(spec string) (docker.RestartPolicy, error) {
+	name, retries := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		name, retries = spec[:i], spec[i+1:]
+	}
+	switch name {
+	case "", "no":
+		if retries == "" {
+			return docker.NeverRestart(), nil
+		}
+	case "always":
+		if retries == "" {
+			return docker.AlwaysRestart(), nil
+		}
+	case "unless-stopped":
+		if retries == "" {
+			return docker.RestartUnlessStopped(), nil
+		}
+	case "on-failure":
+		if retries == "" {
+			return docker.RestartOnFailure(0), nil
+		}
+		n, err := strconv.Atoi(retries)
+		if err != nil || n < 0 {
+			return docker.RestartPolicy{}, fmt.Errorf("bad maximum retry count in restart policy %q", spec)
+		}
+		return docker.RestartOnFailure(n), nil
+	default:
+		return docker.RestartPolicy{}, fmt.Errorf("unknown restart policy %q", spec)
+	}
+	return docker.RestartPolicy{}, fmt.Errorf("restart policy %q doesn't take a maximum retry count", spec)
+}
+
+// exitCommand is the command the containers of the exit-restart
+// scenario run: they fail after exitAfter, for the daemon to restart
+// them.
+func exitCommand() []string {
+	return []string{"sh", "-c", fmt.Sprintf("sleep %d; exit 1", exitAfter)}
+}
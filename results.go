@@ -0,0 +1,759 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/go-units"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// Container verdicts.
+const (
+	verdictOK         = "ok"
+	verdictAffected   = "affected"
+	verdictUnverified = "unverified"
+)
+
+// runResult is the machine readable record of a run, written out as the
+// JSON report when the run exits.
+type runResult struct {
+	mu sync.Mutex
+
+	RunID           string             `json:"run_id"`
+	Start           time.Time          `json:"start"`
+	End             time.Time          `json:"end"`
+	Engine          engineInfo         `json:"engine"`
+	Image           string             `json:"image,omitempty"`
+	ImageID         string             `json:"image_id,omitempty"`
+	Builder         string             `json:"builder,omitempty"`
+	ChaosSeed       int64              `json:"chaos_seed,omitempty"`
+	Seed            int64              `json:"seed,omitempty"`
+	Schedule        []scheduleStep     `json:"schedule,omitempty"`
+	VersionStatus   string             `json:"version_status,omitempty"`
+	Version         map[string]string  `json:"version,omitempty"`
+	Info            json.RawMessage    `json:"info,omitempty"`
+	Containers      []*containerResult `json:"containers"`
+	HungCalls       []*hungCall        `json:"hung_calls,omitempty"`
+	Pings           []pingResult       `json:"pings,omitempty"`
+	DaemonProcs     []procSample       `json:"daemon_procs,omitempty"`
+	HostLoad        []hostLoad         `json:"host_load,omitempty"`
+	DaemonStackDump string             `json:"daemon_stack_dump,omitempty"`
+	Daemon          daemonIdentity     `json:"daemon"`
+	DaemonRestarts  []daemonRestart    `json:"daemon_restarts,omitempty"`
+	Invalid         string             `json:"invalid,omitempty"`
+	Symptoms        []string           `json:"symptoms,omitempty"`
+	Experiment      []factorResult     `json:"experiment,omitempty"`
+	Verdict         string             `json:"verdict"`
+	ExitCode        int                `json:"exit_code"`
+}
+
+type containerResult struct {
+	ID                string            `json:"id"`
+	Control           bool              `json:"control,omitempty"`
+	Cohort            string            `json:"cohort,omitempty"`
+	State             string            `json:"state,omitempty"`
+	Exited            bool              `json:"exited"`
+	MaxExtraProcesses int               `json:"max_extra_processes,omitempty"`
+	ProcessesPiledUp  bool              `json:"processes_piled_up,omitempty"`
+	ExecIDCounts      []int             `json:"exec_id_counts,omitempty"`
+	ExecsLeaked       bool              `json:"execs_leaked,omitempty"`
+	ExitCode          int               `json:"exit_code"`
+	RestartCount      int               `json:"restart_count,omitempty"`
+	StoppedBy         string            `json:"stopped_by,omitempty"`
+	Health            string            `json:"health,omitempty"`
+	HealthTransitions int               `json:"health_transitions"`
+	HealthTimeline    []healthChange    `json:"health_timeline,omitempty"`
+	HealthProbes      []time.Time       `json:"-"`
+	HealthState       *docker.Health    `json:"health_state,omitempty"`
+	ProbeInterval     time.Duration     `json:"probe_interval,omitempty"`
+	MaxProbeGap       time.Duration     `json:"max_probe_gap,omitempty"`
+	Cadence           string            `json:"cadence,omitempty"`
+	StatsSamples      int               `json:"stats_samples"`
+	LastStatsSample   time.Time         `json:"last_stats_sample"`
+	StatsDropped      int               `json:"stats_dropped,omitempty"`
+	WorkloadProbes    []workloadProbe   `json:"workload_probes,omitempty"`
+	Ops               []opResult        `json:"ops"`
+	Errors            map[string]int    `json:"errors,omitempty"`
+	Hangs             []hangAttribution `json:"hangs,omitempty"`
+	Verdict           string            `json:"verdict"`
+}
+
+// opResult is a single daemon call made against a container.
+type opResult struct {
+	Op         string        `json:"op"`
+	Start      time.Time     `json:"start"`
+	Duration   time.Duration `json:"duration"`
+	Error      string        `json:"error,omitempty"`
+	ErrorClass string        `json:"error_class,omitempty"`
+}
+
+// results is the record of the current run.
+var results = &runResult{}
+
+func (r *runResult) container(id string) *containerResult {
+	for _, c := range r.Containers {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+// addContainer records a container of the run. Control containers have
+// their healthcheck disabled, cohort is the container's cohort in an
+// experiment.
+func (r *runResult) addContainer(id string, control bool, cohort string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.container(id) == nil {
+		r.Containers = append(r.Containers, &containerResult{ID: id, Control: control, Cohort: cohort, Verdict: verdictOK})
+	}
+}
+
+// recordExit records that a killed container was seen to exit, with
+// code.
+func (r *runResult) recordExit(id string, code int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c := r.container(id); c != nil {
+		c.Exited = true
+		c.ExitCode = code
+	}
+}
+
+// recordWorkloadProbe records a probe of the container's workload made
+// at start.
+func (r *runResult) recordWorkloadProbe(id string, start time.Time, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := r.container(id)
+	if c == nil {
+		return
+	}
+	p := workloadProbe{Time: start, Duration: time.Since(start)}
+	if err != nil {
+		p.Error = err.Error()
+	}
+	c.WorkloadProbes = append(c.WorkloadProbes, p)
+}
+
+// recordRestarts records how often the daemon restarted the container,
+// as its inspect says.
+func (r *runResult) recordRestarts(id string, count int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c := r.container(id); c != nil {
+		c.RestartCount = count
+	}
+}
+
+// recordStoppedBy records which step of an escalation finally stopped
+// the container.
+func (r *runResult) recordStoppedBy(id, step string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c := r.container(id); c != nil {
+		c.StoppedBy = step
+	}
+}
+
+// recordTop records how many processes besides its own a container
+// ran. It returns true the first time the count reaches threshold.
+func (r *runResult) recordTop(id string, extra, threshold int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := r.container(id)
+	if c == nil {
+		return false
+	}
+	if extra > c.MaxExtraProcesses {
+		c.MaxExtraProcesses = extra
+	}
+	if threshold > 0 && extra >= threshold && !c.ProcessesPiledUp {
+		c.ProcessesPiledUp = true
+		return true
+	}
+	return false
+}
+
+// recordExecIDs records the length of a container's exec list. The
+// container's execs leaked if the list grew over the run past the
+// expected number of execs in flight, unless that is unknown (< 0).
+func (r *runResult) recordExecIDs(id string, n, expected int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := r.container(id)
+	if c == nil {
+		return
+	}
+	c.ExecIDCounts = append(c.ExecIDCounts, n)
+	if expected >= 0 && n > expected && n > c.ExecIDCounts[0] {
+		c.ExecsLeaked = true
+	}
+}
+
+// isControl returns whether the container is a control without a
+// healthcheck.
+func (r *runResult) isControl(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := r.container(id)
+	return c != nil && c.Control
+}
+
+// affectedControls counts the control containers that were affected.
+func (r *runResult) affectedControls() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, c := range r.Containers {
+		if c.Control && c.Verdict == verdictAffected {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *runResult) recordOp(id, op string, start time.Time, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := r.container(id)
+	if c == nil {
+		return
+	}
+	res := opResult{Op: op, Start: start, Duration: time.Since(start)}
+	if err != nil {
+		res.Error = err.Error()
+		res.ErrorClass = classifyError(err)
+		if c.Errors == nil {
+			c.Errors = map[string]int{}
+		}
+		c.Errors[res.ErrorClass]++
+	}
+	c.Ops = append(c.Ops, res)
+}
+
+// healthChange is a health_status event of a container.
+type healthChange struct {
+	Time   time.Time `json:"time"`
+	Status string    `json:"status"`
+}
+
+// recordHealthEvent adds a health_status event to the container's
+// health timeline.
+func (r *runResult) recordHealthEvent(id, status string, t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c := r.container(id); c != nil {
+		c.HealthTimeline = append(c.HealthTimeline, healthChange{Time: t, Status: status})
+	}
+}
+
+// recordHealthProbe records that a healthcheck probe of the container
+// started at t.
+func (r *runResult) recordHealthProbe(id string, t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c := r.container(id); c != nil {
+		c.HealthProbes = append(c.HealthProbes, t)
+	}
+}
+
+// recordHealthState records the healthcheck state of an affected
+// container, as inspected after the run.
+func (r *runResult) recordHealthState(id string, health docker.Health) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c := r.container(id); c != nil {
+		c.HealthState = &health
+	}
+}
+
+// recordHealth notes the container's latest health status, counting it
+// as a transition when it differs from the last one seen.
+func (r *runResult) recordHealth(id, status string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := r.container(id)
+	if c == nil || status == "" || c.Health == status {
+		return
+	}
+	if c.Health != "" {
+		c.HealthTransitions++
+	}
+	c.Health = status
+}
+
+// recordState records the state a container event left the container
+// in.
+func (r *runResult) recordState(id, state string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c := r.container(id); c != nil {
+		c.State = state
+	}
+}
+
+func (r *runResult) recordStatsSample(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c := r.container(id); c != nil {
+		c.StatsSamples++
+		c.LastStatsSample = time.Now()
+	}
+}
+
+// recordStatsDropped counts a stats sample of the container that was
+// dropped before it was written. It returns true for the first one.
+func (r *runResult) recordStatsDropped(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := r.container(id)
+	if c == nil {
+		return false
+	}
+	c.StatsDropped++
+	return c.StatsDropped == 1
+}
+
+func (r *runResult) setVerdict(id, verdict string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c := r.container(id); c != nil {
+		c.Verdict = verdict
+	}
+}
+
+func (r *runResult) addHungCall(id, op string, start time.Time, timeout time.Duration) *hungCall {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	call := &hungCall{ContainerID: id, Op: op, Start: start, Timeout: timeout}
+	r.HungCalls = append(r.HungCalls, call)
+	return call
+}
+
+func (r *runResult) setVersionStatus(status string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.VersionStatus = status
+}
+
+func (r *runResult) setVersion(version map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Version = version
+}
+
+func (r *runResult) setInfo(info json.RawMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Info = info
+}
+
+func (r *runResult) setImage(name, id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Image = name
+	r.ImageID = id
+}
+
+// setBuilder records the builder the run built its image with.
+func (r *runResult) setBuilder(builder string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Builder = builder
+}
+
+func (r *runResult) setChaosSeed(seed int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ChaosSeed = seed
+}
+
+func (r *runResult) setSeed(seed int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Seed = seed
+}
+
+// recordStep records a step of a shuffled run's schedule.
+func (r *runResult) recordStep(step scheduleStep) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Schedule = append(r.Schedule, step)
+}
+
+func (r *runResult) imageID() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ImageID
+}
+
+func (r *runResult) addPing(ping pingResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Pings = append(r.Pings, ping)
+}
+
+// lastPing returns the most recent heartbeat ping, if there was one.
+func (r *runResult) lastPing() (pingResult, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.Pings) == 0 {
+		return pingResult{}, false
+	}
+	return r.Pings[len(r.Pings)-1], true
+}
+
+func (r *runResult) addProcSample(sample procSample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.DaemonProcs = append(r.DaemonProcs, sample)
+}
+
+func (r *runResult) addHostLoad(l hostLoad) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.HostLoad = append(r.HostLoad, l)
+}
+
+func (r *runResult) setDaemon(id daemonIdentity) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Daemon = id
+}
+
+// addDaemonRestart records a daemon restart and invalidates the run.
+func (r *runResult) addDaemonRestart(restart daemonRestart) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.DaemonRestarts = append(r.DaemonRestarts, restart)
+	r.Invalid = "dockerd restarted during the run"
+}
+
+// invalid returns why the run's results are misleading, if they are.
+func (r *runResult) invalid() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Invalid
+}
+
+func (r *runResult) setDaemonStackDump(path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.DaemonStackDump = path
+}
+
+// hungCallReturned records that call finally returned, and how long it
+// took in total.
+func (r *runResult) hungCallReturned(call *hungCall, err error) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	call.Returned = true
+	call.ReturnedAfter = time.Since(call.Start)
+	if err != nil {
+		call.Error = err.Error()
+	}
+	return call.ReturnedAfter
+}
+
+// finish records the run's final verdict and writes the JSON report.
+func (r *runResult) finish(code int, verdict string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.End = time.Now()
+	r.ExitCode = code
+	r.Verdict = verdict
+	for _, call := range r.HungCalls {
+		if !call.Returned {
+			call.ReturnedAfter = r.End.Sub(call.Start)
+		}
+	}
+	for _, c := range r.Containers {
+		c.checkCadence(r.End)
+	}
+	r.attributeHangs()
+	r.Symptoms = r.classify()
+	r.Experiment = r.compareCohorts()
+
+	name := runPath("results.json")
+	f, err := os.Create(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return name, enc.Encode(r)
+}
+
+// printSummary writes an aligned, per container summary of the run
+// followed by the run's verdict.
+func (r *runResult) printSummary(out io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e := r.Engine; e.Version != "" {
+		fmt.Fprintf(out, "Engine %s (API %s, %s) on %s, kernel %s\n", e.Version, e.APIVersion, e.GoVersion, e.OperatingSystem, e.KernelVersion)
+		fmt.Fprintf(out, "Storage driver %s, cgroup driver %s, containerd %s, runc %s\n", e.StorageDriver, e.CgroupDriver, e.ContainerdCommit, e.RuncCommit)
+	}
+	if r.ImageID != "" {
+		built := ""
+		if r.Builder != "" {
+			built = ", built with the " + r.Builder + " builder"
+		}
+		fmt.Fprintf(out, "Image %s (%s)%s\n", r.Image, r.ImageID, built)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONTAINER\tHEALTH TRANSITIONS\tINSPECT P50\tINSPECT P99\tSTATS SAMPLES\tERRORS\tVERDICT")
+	for _, c := range r.Containers {
+		inspects := c.durations("inspect")
+		verdict := c.Verdict
+		if c.Control {
+			verdict += " (control)"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%d\t%s\t%s\n",
+			shortID(c.ID),
+			c.HealthTransitions,
+			formatDuration(percentile(inspects, 50)),
+			formatDuration(percentile(inspects, 99)),
+			c.StatsSamples,
+			formatErrors(c.Errors),
+			verdict,
+		)
+	}
+	tw.Flush()
+	r.printHangs(out)
+	if len(r.Pings) != 0 {
+		var durations []time.Duration
+		failed := 0
+		for _, p := range r.Pings {
+			if p.Error != "" {
+				failed++
+				continue
+			}
+			durations = append(durations, p.Duration)
+		}
+		fmt.Fprintf(out, "Daemon pings: %d, %d failed, p50 %s, p99 %s\n",
+			len(r.Pings), failed,
+			formatDuration(percentile(durations, 50)),
+			formatDuration(percentile(durations, 99)))
+	}
+	r.printRemovals(out)
+	r.printProcPeaks(out)
+	for _, l := range r.HostLoad {
+		if l.Breach != "" {
+			fmt.Fprintf(out, "Host overloaded at %s: %s\n", l.Time.Format(time.RFC3339), l.Breach)
+		}
+	}
+	for _, restart := range r.DaemonRestarts {
+		fmt.Fprintf(out, "dockerd restarted by %s: %s -> %s\n", restart.Time.Format(time.RFC3339), restart.Before, restart.After)
+	}
+	if r.DaemonStackDump != "" {
+		fmt.Fprintf(out, "dockerd goroutine stacks: %s\n", r.DaemonStackDump)
+	}
+	r.printHealthTimelines(out)
+	for _, c := range r.Containers {
+		if failed := c.failedWorkloadProbes(); failed != 0 {
+			fmt.Fprintf(out, "Workload of %s didn't answer %d of %d probes\n", shortID(c.ID), failed, len(c.WorkloadProbes))
+		}
+		if c.RestartCount != 0 {
+			fmt.Fprintf(out, "%s was restarted %d times by the daemon\n", shortID(c.ID), c.RestartCount)
+		}
+		if c.StoppedBy != "" && c.StoppedBy != escalateStop {
+			fmt.Fprintf(out, "%s only stopped with a %s\n", shortID(c.ID), c.StoppedBy)
+		}
+		if c.StatsDropped != 0 {
+			fmt.Fprintf(out, "Dropped %d stats sample(s) of %s\n", c.StatsDropped, shortID(c.ID))
+		}
+		if c.ExecsLeaked {
+			fmt.Fprintf(out, "Exec list of %s grew: %v\n", shortID(c.ID), c.ExecIDCounts)
+		}
+		if h := c.HealthState; h != nil {
+			line := fmt.Sprintf("Health state of %s after the run: %s, failing streak %d", shortID(c.ID), h.Status, h.FailingStreak)
+			if n := len(h.Log); n != 0 {
+				probe := h.Log[n-1]
+				line += fmt.Sprintf(", last probe at %s exited %d", probe.Start.Format("15:04:05"), probe.ExitCode)
+			}
+			fmt.Fprintln(out, line)
+		}
+		switch c.Cadence {
+		case cadenceDrifted:
+			fmt.Fprintf(out, "Healthcheck cadence of %s drifted: probes every %s on average, longest gap %s (interval %s)\n",
+				shortID(c.ID), formatDuration(c.ProbeInterval), formatDuration(c.MaxProbeGap), healthcheckInterval)
+		case cadenceStopped:
+			fmt.Fprintf(out, "Healthcheck cadence of %s stopped: %d probe(s), the last one before the checks started\n",
+				shortID(c.ID), len(c.HealthProbes))
+		}
+	}
+	if len(r.Symptoms) != 0 {
+		fmt.Fprintf(out, "Symptoms: %s\n", strings.Join(r.Symptoms, ", "))
+	}
+	if len(r.Experiment) != 0 {
+		r.printCohorts(out)
+	}
+	fmt.Fprintf(out, "Run %s: %s (exit %d)\n", r.RunID, r.Verdict, r.ExitCode)
+}
+
+// printHealthTimelines prints the health_status events of each
+// container. When calls hung, the last event is put in relation to the
+// first hang, which tells whether the healthcheck monitor stalled before
+// or after the API did.
+func (r *runResult) printHealthTimelines(out io.Writer) {
+	var firstHang time.Time
+	for _, call := range r.HungCalls {
+		if firstHang.IsZero() || call.Start.Before(firstHang) {
+			firstHang = call.Start
+		}
+	}
+	for _, c := range r.Containers {
+		if len(c.HealthTimeline) == 0 {
+			continue
+		}
+		var changes []string
+		for _, h := range c.HealthTimeline {
+			changes = append(changes, h.Status+" "+h.Time.Format("15:04:05"))
+		}
+		line := fmt.Sprintf("Health of %s: %s", shortID(c.ID), strings.Join(changes, ", "))
+		if !firstHang.IsZero() {
+			last := c.HealthTimeline[len(c.HealthTimeline)-1].Time
+			if last.Before(firstHang) {
+				line += fmt.Sprintf(" (last change %s before the first hang)", formatDuration(firstHang.Sub(last)))
+			} else {
+				line += fmt.Sprintf(" (last change %s after the first hang)", formatDuration(last.Sub(firstHang)))
+			}
+		}
+		fmt.Fprintln(out, line)
+	}
+}
+
+// printProcPeaks prints the peak resource usage of each sampled daemon
+// process.
+func (r *runResult) printProcPeaks(out io.Writer) {
+	var order []string
+	peaks := map[string]*procSample{}
+	for _, sample := range r.DaemonProcs {
+		peak, ok := peaks[sample.Process]
+		if !ok {
+			order = append(order, sample.Process)
+			peak = &procSample{Process: sample.Process}
+			peaks[sample.Process] = peak
+		}
+		if sample.RSSBytes > peak.RSSBytes {
+			peak.RSSBytes = sample.RSSBytes
+		}
+		if sample.CPUPercent > peak.CPUPercent {
+			peak.CPUPercent = sample.CPUPercent
+		}
+		if sample.Threads > peak.Threads {
+			peak.Threads = sample.Threads
+		}
+	}
+	for _, name := range order {
+		peak := peaks[name]
+		fmt.Fprintf(out, "%s peaks: rss %s, cpu %.1f%%, threads %d\n",
+			name, units.BytesSize(float64(peak.RSSBytes)), peak.CPUPercent, peak.Threads)
+	}
+}
+
+func (c *containerResult) durations(op string) []time.Duration {
+	var ds []time.Duration
+	for _, o := range c.Ops {
+		if o.Op == op {
+			ds = append(ds, o.Duration)
+		}
+	}
+	return ds
+}
+
+// printRemovals prints how long the containers took to be removed. A
+// forced removal racing an in-flight healthcheck exec is suspected to
+// hang on its own.
+func (r *runResult) printRemovals(out io.Writer) {
+	var durations []time.Duration
+	failed := 0
+	for _, c := range r.Containers {
+		for _, o := range c.Ops {
+			if o.Op != "remove" {
+				continue
+			}
+			if o.Error != "" {
+				failed++
+			}
+			durations = append(durations, o.Duration)
+		}
+	}
+	if len(durations) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "Removals: %d, %d failed, p50 %s, p99 %s, max %s\n",
+		len(durations), failed,
+		formatDuration(percentile(durations, 50)),
+		formatDuration(percentile(durations, 99)),
+		formatDuration(percentile(durations, 100)))
+}
+
+// percentile returns the nearest-rank pth percentile of ds, or -1 if
+// there is nothing to rank.
+func percentile(ds []time.Duration, p int) time.Duration {
+	if len(ds) == 0 {
+		return -1
+	}
+	sorted := append([]time.Duration(nil), ds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func formatDuration(d time.Duration) string {
+	if d < 0 {
+		return "-"
+	}
+	return d.Round(time.Millisecond).String()
+}
+
+func formatErrors(errs map[string]int) string {
+	if len(errs) == 0 {
+		return "-"
+	}
+	var parts []string
+	for class, n := range errs {
+		parts = append(parts, fmt.Sprintf("%s=%d", class, n))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,158 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// rotationPolicy caps how large artifact logs are allowed to grow.
+type rotationPolicy struct {
+	// MaxSize rolls the file over once it would grow past this many
+	// bytes, zero disables size based rotation.
+	MaxSize int64
+	// MaxAge rolls the file over once it has been open this long, zero
+	// disables time based rotation.
+	MaxAge time.Duration
+	// Keep is the number of rolled over segments to retain, zero keeps
+	// all of them.
+	Keep int
+	// Compress gzips segments as they are rolled over.
+	Compress bool
+}
+
+// rotatingFile is a log file that is rolled over to numbered segments
+// (name.1, name.2, ...) according to its rotationPolicy, so a long
+// soak doesn't fill the disk.
+type rotatingFile struct {
+	name   string
+	policy rotationPolicy
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	opened  time.Time
+	segment int
+}
+
+func openRotatingFile(name string, policy rotationPolicy) (*rotatingFile, error) {
+	r := &rotatingFile{name: name, policy: policy}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	r.file = f
+	r.size = 0
+	r.opened = time.Now()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.due(int64(len(p))) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// due reports whether writing n more bytes should first roll the
+// file over. An empty file is never rotated, however large the write.
+func (r *rotatingFile) due(n int64) bool {
+	if r.size == 0 {
+		return false
+	}
+	if r.policy.MaxSize > 0 && r.size+n > r.policy.MaxSize {
+		return true
+	}
+	return r.policy.MaxAge > 0 && time.Since(r.opened) > r.policy.MaxAge
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.segment++
+	segment := r.segmentName(r.segment)
+	if err := os.Rename(r.name, segment); err != nil {
+		return err
+	}
+	if r.policy.Compress {
+		if err := gzipFile(segment); err != nil {
+			logger.WithError(err).WithField("path", segment).Warn("Could not compress rotated log segment")
+		}
+	}
+	if r.policy.Keep > 0 && r.segment > r.policy.Keep {
+		expired := r.segmentName(r.segment - r.policy.Keep)
+		os.Remove(expired)
+		os.Remove(expired + ".gz")
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) segmentName(n int) string {
+	return fmt.Sprintf("%s.%d", r.name, n)
+}
+
+// gzipFile replaces name with name.gz.
+func gzipFile(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
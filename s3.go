@@ -0,0 +1,369 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	s3UploadTimeout = 10 * time.Minute
+	// credentialsTimeout bounds each metadata endpoint lookup, which
+	// don't answer at all off EC2 and ECS.
+	credentialsTimeout = 2 * time.Second
+
+	ecsCredentialsHost = "http://169.254.170.2"
+	imdsHost           = "http://169.254.169.254"
+)
+
+var (
+	s3Bucket string
+	s3Prefix string
+	s3Region string
+)
+
+// awsCredentials are the keys requests are signed with.
+type awsCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+}
+
+// uploadBundle puts the bundle at path to --s3-bucket under --s3-prefix
+// and returns its S3 URL.
+func uploadBundle(path string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3UploadTimeout)
+	defer cancel()
+
+	creds, err := findAWSCredentials(ctx)
+	if err != nil {
+		return "", err
+	}
+	region := s3Region
+	if region == "" {
+		region = firstEnv("AWS_REGION", "AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	key := strings.TrimPrefix(s3Prefix+filepath.Base(path), "/")
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest("PUT", s3ObjectURL(s3Bucket, region, key), f)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/gzip")
+	signS3Request(req, creds, region, hex.EncodeToString(h.Sum(nil)), time.Now())
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", fmt.Errorf("PUT s3://%s/%s: %s: %s", s3Bucket, key, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return fmt.Sprintf("s3://%s/%s", s3Bucket, key), nil
+}
+
+// signS3Request adds an AWS Signature Version 4 authorization to req,
+// whose payload hashes to payloadHash.
+func signS3Request(req *http.Request, creds awsCredentials, region, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.Token)
+	}
+
+	canonical, signedHeaders := canonicalRequest(req, payloadHash)
+	scope := date + "/" + region + "/s3/aws4_request"
+	key := signingKey(creds.SecretAccessKey, date, region, "s3")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign(amzDate, scope, canonical)))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalRequest returns the SigV4 canonical form of req, which signs
+// its host and every header it has, and the list of signed headers.
+func canonicalRequest(req *http.Request, payloadHash string) (string, string) {
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	return strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n"), signedHeaders
+}
+
+func stringToSign(amzDate, scope, canonicalRequest string) string {
+	return strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+}
+
+// signingKey derives the key of secret for signing requests to service
+// in region on date.
+func signingKey(secret, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// s3ObjectURL returns the URL of key in bucket. Buckets are addressed
+// virtual-hosted style, except those with dots in their names, which
+// don't match the wildcard certificate of the S3 endpoint and so are
+// addressed path style.
+func s3ObjectURL(bucket, region, key string) string {
+	if strings.Contains(bucket, ".") {
+		return fmt.Sprintf("https://s3.%s.amazonaws.com/%s/%s", region, bucket, s3EscapePath(key))
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, region, s3EscapePath(key))
+}
+
+// s3EscapePath escapes an object key the way SigV4 expects, everything
+// but unreserved characters and the path separators.
+func s3EscapePath(key string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// findAWSCredentials looks for credentials the way the AWS SDKs do: the
+// environment, the shared credentials file, the ECS task role and the
+// EC2 instance role, in that order.
+func findAWSCredentials(ctx context.Context) (awsCredentials, error) {
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return awsCredentials{AccessKeyID: id, SecretAccessKey: secret, Token: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	if creds, err := sharedCredentials(); err == nil {
+		return creds, nil
+	}
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		var creds awsCredentials
+		if err := getCredentialsJSON(ctx, ecsCredentialsHost+uri, "", &creds); err == nil {
+			return creds, nil
+		}
+	}
+	if creds, err := instanceCredentials(ctx); err == nil {
+		return creds, nil
+	}
+	return awsCredentials{}, fmt.Errorf("no AWS credentials found")
+}
+
+// sharedCredentials reads the AWS_PROFILE, or default, profile from the
+// shared credentials file.
+func sharedCredentials() (awsCredentials, error) {
+	var creds awsCredentials
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		path = filepath.Join(os.Getenv("HOME"), ".aws", "credentials")
+	}
+	profile := firstEnv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return creds, err
+	}
+	defer f.Close()
+	section := ""
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != profile {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(kv[1])
+		switch strings.TrimSpace(kv[0]) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = value
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = value
+		case "aws_session_token":
+			creds.Token = value
+		}
+	}
+	if err := s.Err(); err != nil {
+		return creds, err
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return creds, fmt.Errorf("no credentials for profile %s in %s", profile, path)
+	}
+	return creds, nil
+}
+
+// instanceCredentials fetches the EC2 instance role's credentials from
+// the instance metadata service, with an IMDSv2 session token.
+func instanceCredentials(ctx context.Context) (awsCredentials, error) {
+	var creds awsCredentials
+	tctx, cancel := context.WithTimeout(ctx, credentialsTimeout)
+	defer cancel()
+	req, err := http.NewRequest("PUT", imdsHost+"/latest/api/token", nil)
+	if err != nil {
+		return creds, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+	resp, err := http.DefaultClient.Do(req.WithContext(tctx))
+	if err != nil {
+		return creds, err
+	}
+	token, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return creds, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return creds, fmt.Errorf("PUT %s/latest/api/token: %s", imdsHost, resp.Status)
+	}
+
+	const rolePath = "/latest/meta-data/iam/security-credentials/"
+	role, err := getMetadata(ctx, imdsHost+rolePath, string(token))
+	if err != nil {
+		return creds, err
+	}
+	role = strings.TrimSpace(strings.SplitN(role, "\n", 2)[0])
+	err = getCredentialsJSON(ctx, imdsHost+rolePath+role, string(token), &creds)
+	return creds, err
+}
+
+func getMetadata(ctx context.Context, u, token string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, credentialsTimeout)
+	defer cancel()
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return "", err
+	}
+	if token != "" {
+		req.Header.Set("X-aws-ec2-metadata-token", token)
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	return string(b), err
+}
+
+func getCredentialsJSON(ctx context.Context, u, token string, creds *awsCredentials) error {
+	body, err := getMetadata(ctx, u, token)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(body), creds); err != nil {
+		return err
+	}
+	if creds.AccessKeyID == "" {
+		return fmt.Errorf("no credentials in %s", u)
+	}
+	return nil
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
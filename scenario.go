@@ -0,0 +1,260 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+const (
+	// scenarioParallel starts all containers back to back.
+	scenarioParallel = "parallel"
+	// scenarioDependsOnHealthy only starts the second container once
+	// the first reports healthy, the way compose's depends_on with
+	// condition: service_healthy does.
+	scenarioDependsOnHealthy = "depends-on-healthy"
+	// scenarioChurn keeps stopping and restarting the containers with a
+	// healthcheck while the run waits, the way an orchestrator's
+	// workload does, so that stops race healthcheck execs.
+	scenarioChurn = "churn"
+
+	// scenarioExecFlood keeps making execs in the containers while the
+	// run waits, next to the daemon's own healthcheck execs, to contend
+	// on the daemon's exec bookkeeping.
+	scenarioExecFlood = "exec-flood"
+
+	// scenarioAutoRemove creates the containers with auto-remove, so
+	// that the daemon removes them once the kill check stopped them,
+	// racing the healthcheck monitor on the way out.
+	scenarioAutoRemove = "auto-remove"
+
+	// scenarioExitRestart runs containers that exit every --exit-after,
+	// for the daemon to restart them as their restart policy says, so
+	// their healthchecks are set up again and again.
+	scenarioExitRestart = "exit-restart"
+
+	// scenarioHTTPProbe runs an HTTP listener in the containers, on a
+	// published port the tool probes from the host, to tell whether
+	// only the daemon's API hangs or the workload stalls too.
+	scenarioHTTPProbe = "http-probe"
+
+	// churnStopGrace is how long a churned container gets to stop
+	// before it is killed. The test image's sleep doesn't handle
+	// SIGTERM, so it always is.
+	churnStopGrace = 1
+
+	healthyGateTimeout = time.Minute
+)
+
+var (
+	churnInterval time.Duration
+	execRate      float64
+)
+
+func validScenario(name string) bool {
+	switch name {
+	case scenarioParallel, scenarioDependsOnHealthy, scenarioChurn, scenarioExecFlood, scenarioAutoRemove, scenarioExitRestart, scenarioHTTPProbe:
+		return true
+	}
+	return false
+}
+
+// restartsContainers reports whether the scenario stops and starts the
+// containers while the run waits.
+func restartsContainers() bool {
+	return scenario == scenarioChurn || scenario == scenarioExitRestart
+}
+
+// waitForHealthy polls the container with inspect until its healthcheck
+// reports healthy. Each poll is bounded by the usual call timeout, so a
+// hang in the gate itself is reported like any other inspect hang.
+func waitForHealthy(client *docker.Client, cont *docker.Container) error {
+	clog := logger.WithField("container_id", cont.ID)
+	deadline := time.Now().Add(healthyGateTimeout)
+
+	for {
+		var insp *docker.Container
+		start := time.Now()
+		err := watchCall(rootCtx, cont.ID, "inspect", time.Duration(callTimeoutSecs)*time.Second, func(ctx context.Context) (err error) {
+			insp, err = client.InspectContainerWithContext(cont.ID, ctx)
+			return err
+		})
+		olog := finishOp(clog, cont.ID, "inspect", start, err)
+		if classifyError(err) == errClassTimeout {
+			hangDetected(client, cont, "inspect", err)
+		}
+		if err != nil {
+			olog.Error("Error inspecting container while waiting for it to become healthy")
+			return err
+		}
+
+		noteExecIDs(insp)
+		status := insp.State.Health.Status
+		results.recordHealth(cont.ID, status)
+		olog.WithField("health", status).Debug("Waiting for container to become healthy")
+		switch {
+		case status == "healthy":
+			olog.Info("Container is healthy")
+			return nil
+		case status == "unhealthy":
+			return fmt.Errorf("container %s became unhealthy before its dependent was started", cont.ID)
+		case !insp.State.Running:
+			return fmt.Errorf("container %s exited before becoming healthy", cont.ID)
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for container %s to become healthy", healthyGateTimeout, cont.ID)
+		}
+		if err := sleepCtx(rootCtx, time.Second); err != nil {
+			return err
+		}
+	}
+}
+
+// churn stops and restarts conts one after the other, every interval,
+// until ctx is done. Both calls are bounded by the call timeout and a
+// container that hung is left alone.
+func churn(ctx context.Context, client *docker.Client, conts []*docker.Container, interval time.Duration) {
+	callTimeout := time.Duration(callTimeoutSecs) * time.Second
+	for {
+		for _, cont := range conts {
+			if err := sleepCtx(ctx, interval); err != nil {
+				return
+			}
+			if isAffected(cont.ID) {
+				continue
+			}
+			clog := logger.WithField("container_id", cont.ID)
+			for _, op := range []string{"stop", "start"} {
+				start := time.Now()
+				err := watchCall(ctx, cont.ID, op, callTimeout, func(ctx context.Context) error {
+					if op == "stop" {
+						return client.StopContainerWithContext(cont.ID, churnStopGrace, ctx)
+					}
+					return client.StartContainerWithContext(cont.ID, nil, ctx)
+				})
+				if ctx.Err() != nil {
+					return
+				}
+				olog := finishOp(clog, cont.ID, op, start, err)
+				if classifyError(err) == errClassTimeout {
+					hangDetected(client, cont, op, err)
+				}
+				if err != nil {
+					olog.Warn("Could not churn container")
+					break
+				}
+				olog.Debug("Churned container")
+			}
+		}
+	}
+}
+
+// execFlood runs echo hello in each of conts, rate times a second per
+// container, until ctx is done. Execs don't wait for each other, so a
+// slow daemon piles them up the way healthchecks would. Only execs that
+// fail are recorded, there are too many to record all of them.
+func execFlood(ctx context.Context, client *docker.Client, conts []*docker.Container, rate float64) {
+	callTimeout := time.Duration(callTimeoutSecs) * time.Second
+	tick := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer tick.Stop()
+	execs := 0
+	defer func() {
+		logger.WithField("execs", execs).Info("Exec flood stopped")
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		for _, cont := range conts {
+			if isAffected(cont.ID) {
+				continue
+			}
+			cont := cont
+			execs++
+			goSafe(func() {
+				var exec *docker.Exec
+				for _, op := range []string{"exec-create", "exec-start"} {
+					start := time.Now()
+					err := watchCall(ctx, cont.ID, op, callTimeout, func(ctx context.Context) (err error) {
+						if op == "exec-create" {
+							exec, err = client.CreateExec(docker.CreateExecOptions{
+								Container:    cont.ID,
+								Cmd:          []string{"echo", "hello"},
+								AttachStdout: true,
+								AttachStderr: true,
+								Context:      ctx,
+							})
+							return err
+						}
+						return client.StartExec(exec.ID, docker.StartExecOptions{
+							OutputStream: ioutil.Discard,
+							ErrorStream:  ioutil.Discard,
+							Context:      ctx,
+						})
+					})
+					if err == nil {
+						continue
+					}
+					if ctx.Err() != nil {
+						return
+					}
+					olog := finishOp(logger.WithField("container_id", cont.ID), cont.ID, op, start, err)
+					if classifyError(err) == errClassTimeout {
+						hangDetected(client, cont, op, err)
+					}
+					olog.Warn("Exec failed")
+					return
+				}
+			})
+		}
+	}
+}
+
+// waitForAutoRemove polls the stopped container with inspect until the
+// daemon auto-removed it. A container still there after timeout is
+// recorded as a hang of its auto-remove.
+func waitForAutoRemove(client *docker.Client, cont *docker.Container, timeout time.Duration) error {
+	olog, err := timedOp(client, cont, "auto-remove", timeout, func(ctx context.Context) error {
+		for {
+			_, err := client.InspectContainerWithContext(cont.ID, ctx)
+			if _, ok := err.(*docker.NoSuchContainer); ok {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	})
+	if err != nil {
+		olog.Error("Container was not auto removed")
+		return err
+	}
+	olog.Info("Container was auto removed")
+	return nil
+}
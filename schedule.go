@@ -0,0 +1,270 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+var (
+	// shuffle interleaves the creates, starts and checks of the
+	// containers in a random order picked from seed. The hang depends
+	// on timing, a recorded seed makes an ordering that hit it
+	// repeatable.
+	shuffle bool
+	seed    int64
+
+	// replayPath is the results.json of a shuffled run whose schedule
+	// is replayed, timings included.
+	replayPath string
+	// replaySteps is the schedule loaded from replayPath.
+	replaySteps []scheduleStep
+)
+
+// Phases of a schedule.
+const (
+	schedulePhaseStart = "start"
+	schedulePhaseCheck = "check"
+)
+
+// scheduleStep is a step of a shuffled run. Steps of the start phase
+// are the create (0) and start (1) of a container, steps of the check
+// phase the preparation (0) and the checks of --ops of a container.
+type scheduleStep struct {
+	Phase     string `json:"phase"`
+	Container int    `json:"container"`
+	Step      int    `json:"step"`
+	// At is when the step was taken, since its phase began.
+	At time.Duration `json:"at"`
+
+	// gap is how long to wait before taking the step.
+	gap time.Duration
+}
+
+// scheduleRand returns the source of the run's random order, nil if the
+// run isn't shuffled.
+func scheduleRand() *rand.Rand {
+	if !shuffle {
+		return nil
+	}
+	return rand.New(rand.NewSource(seed))
+}
+
+// loadReplay loads the schedule to replay from the results of a run,
+// which must have been made with as many containers and the same checks.
+func loadReplay(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var recorded struct {
+		Seed     int64          `json:"seed"`
+		Schedule []scheduleStep `json:"schedule"`
+	}
+	if err := json.NewDecoder(f).Decode(&recorded); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	if len(recorded.Schedule) == 0 {
+		return fmt.Errorf("%s has no schedule, only shuffled runs record one", path)
+	}
+	// Every container has to have all of its steps in both phases.
+	counts := map[scheduleStep]int{}
+	for _, step := range recorded.Schedule {
+		counts[scheduleStep{Phase: step.Phase, Container: step.Container}]++
+	}
+	for i := 0; i < containerCount; i++ {
+		if counts[scheduleStep{Phase: schedulePhaseStart, Container: i}] != 2 ||
+			counts[scheduleStep{Phase: schedulePhaseCheck, Container: i}] != 1+len(checkOps) {
+			return fmt.Errorf("%s was recorded with other --containers or --ops", path)
+		}
+	}
+	if len(recorded.Schedule) != containerCount*(3+len(checkOps)) {
+		return fmt.Errorf("%s was recorded with other --containers or --ops", path)
+	}
+	shuffle = true
+	seed = recorded.Seed
+	replaySteps = recorded.Schedule
+	return nil
+}
+
+// replayed returns the recorded steps of phase.
+func replayed(phase string) []scheduleStep {
+	var steps []scheduleStep
+	for _, step := range replaySteps {
+		if step.Phase == phase {
+			steps = append(steps, step)
+		}
+	}
+	return steps
+}
+
+// interleave returns a random order of the steps of the containers, as
+// container indexes, with steps[i] steps of container i. Each
+// container's own steps stay in order. Picking a container in
+// proportion to the steps it has left makes every interleaving equally
+// likely.
+func interleave(rng *rand.Rand, steps []int) []int {
+	left := append([]int(nil), steps...)
+	total := 0
+	for _, n := range left {
+		total += n
+	}
+	order := make([]int, 0, total)
+	for ; total > 0; total-- {
+		n := rng.Intn(total)
+		for i := range left {
+			if n < left[i] {
+				order = append(order, i)
+				left[i]--
+				break
+			}
+			n -= left[i]
+		}
+	}
+	return order
+}
+
+// plan returns the steps of phase, with stepsEach steps per container,
+// in a random order, or as recorded when replaying.
+func plan(rng *rand.Rand, phase string, stepsEach int) []scheduleStep {
+	if replaySteps != nil {
+		return replayed(phase)
+	}
+	steps := make([]int, containerCount)
+	for i := range steps {
+		steps[i] = stepsEach
+	}
+	done := make([]int, containerCount)
+	var planned []scheduleStep
+	for _, i := range interleave(rng, steps) {
+		planned = append(planned, scheduleStep{Phase: phase, Container: i, Step: done[i]})
+		done[i]++
+	}
+	return planned
+}
+
+// startPlan returns the creates and starts of the containers, with
+// random spacing of up to twice --start-stagger before each start.
+func startPlan(rng *rand.Rand) []scheduleStep {
+	planned := plan(rng, schedulePhaseStart, 2)
+	for i := range planned {
+		if replaySteps == nil && planned[i].Step == 1 && startStagger > 0 {
+			planned[i].gap = time.Duration(rng.Int63n(int64(2 * startStagger)))
+		}
+	}
+	return planned
+}
+
+// checkPlan returns the checks of the containers once the run is over.
+func checkPlan(rng *rand.Rand) []scheduleStep {
+	return plan(rng, schedulePhaseCheck, 1+len(checkOps))
+}
+
+// delays returns how long to wait before each of the planned steps.
+// Replayed steps are taken when they were recorded to be, as far as the
+// steps before them allow.
+func delays(planned []scheduleStep) []time.Duration {
+	waits := make([]time.Duration, len(planned))
+	var last time.Duration
+	for i, step := range planned {
+		waits[i] = step.gap
+		if replaySteps != nil {
+			waits[i] = step.At - last
+			last = step.At
+		}
+	}
+	return waits
+}
+
+// runSchedule takes the planned steps with fn, recording when each was
+// taken.
+func runSchedule(planned []scheduleStep, fn func(step scheduleStep)) {
+	began := time.Now()
+	for _, step := range planned {
+		wait := step.gap
+		if replaySteps != nil {
+			wait = step.At - time.Since(began)
+		}
+		if wait > 0 {
+			failOnError(sleepCtx(rootCtx, wait))
+		}
+		step.At = time.Since(began)
+		results.recordStep(step)
+		fn(step)
+	}
+}
+
+// createAndStartShuffled creates and starts the run's containers with
+// the creates and starts interleaved at random. It returns the
+// containers in index order, and those to stream stats from.
+func createAndStartShuffled(client *docker.Client, rng *rand.Rand) (conts, streamed []*docker.Container) {
+	conts = make([]*docker.Container, containerCount)
+	runSchedule(startPlan(rng), func(step scheduleStep) {
+		i := step.Container
+		if step.Step == 0 {
+			control, cohortName, _ := containerRole(i)
+			cont, err := createContainer(client, control, cohortName)
+			failOnError(err)
+			conts[i] = cont
+			return
+		}
+		failOnError(client.StartContainerWithContext(conts[i].ID, nil, rootCtx))
+	})
+	for i, cont := range conts {
+		if _, _, stats := containerRole(i); stats {
+			streamed = append(streamed, cont)
+		}
+	}
+	return conts, streamed
+}
+
+// checkContainers checks conts once the run is over, one after the
+// other, or with their checks interleaved at random if rng is set. It
+// returns the error that affected each container, if any.
+func checkContainers(client *docker.Client, conts []*docker.Container, rng *rand.Rand) []error {
+	errs := make([]error, len(conts))
+	if rng == nil {
+		for i, cont := range conts {
+			errs[i] = stopAndCheckContainer(client, cont)
+		}
+		return errs
+	}
+
+	checks := make([]*containerCheck, len(conts))
+	for i, cont := range conts {
+		checks[i] = &containerCheck{cont: cont}
+	}
+	runSchedule(checkPlan(rng), func(step scheduleStep) {
+		check := checks[step.Container]
+		switch {
+		case step.Step == 0:
+			check.prepare(client)
+		case !check.done:
+			check.run(client, checkOps[step.Step-1])
+		}
+	})
+	for i, check := range checks {
+		errs[i] = check.err
+	}
+	return errs
+}
@@ -0,0 +1,566 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Managed run statuses.
+const (
+	runRunning  = "running"
+	runFinished = "finished"
+)
+
+// runRequest is the body of a request to start a run.
+type runRequest struct {
+	// Flags are the run's flags by name, without leading dashes.
+	Flags map[string]string `json:"flags"`
+}
+
+// managedRun is a run started by the server, as a child process of it.
+type managedRun struct {
+	RunID     string            `json:"run_id"`
+	Flags     map[string]string `json:"flags"`
+	Status    string            `json:"status"`
+	Start     time.Time         `json:"start"`
+	End       *time.Time        `json:"end,omitempty"`
+	ExitCode  *int              `json:"exit_code,omitempty"`
+	Verdict   string            `json:"verdict,omitempty"`
+	Scheduled bool              `json:"scheduled,omitempty"`
+
+	cmd *exec.Cmd
+	// done is closed once the run finished.
+	done chan struct{}
+}
+
+// apiFlags are the run flags a request to the control API may set. The
+// runs have the daemon's access, so flags that name host paths, images,
+// URLs, addresses or the daemon's own files and processes are left to
+// whoever starts the server, as flags of its scheduled runs.
+var apiFlags = map[string]bool{
+	"attach": true, "bundle": true, "cadence-tolerance": true, "chaos": true,
+	"chaos-seed": true, "check-exec-ids": true, "check-pause": true,
+	"check-restart": true, "churn-interval": true, "cleanup-on-interrupt": true,
+	"container-cpus": true, "container-memory": true, "container-pids-limit": true,
+	"container-stop-signal": true, "container-stop-timeout": true,
+	"container-ulimits": true, "containers": true, "control-fraction": true,
+	"escalation-timeouts": true, "event-filter": true, "exec-rate": true,
+	"exit-after": true, "experiment": true, "fail-fast": true, "follow-logs": true,
+	"healthcheck-at-create": true, "healthcheck-behavior": true,
+	"healthcheck-interval": true, "healthcheck-retries": true,
+	"healthcheck-start-period": true, "healthcheck-timeout": true,
+	"healthchecks": true, "heartbeat-interval": true, "heartbeat-timeout": true,
+	"init": true, "inspect-qps": true, "issue-report": true, "kill-signal": true,
+	"log-level": true, "max-qps": true, "network-churn-interval": true,
+	"ops": true, "pause-timeout": true, "quiet": true, "remove-containers": true,
+	"remove-force": true, "remove-volumes": true, "restart-policy": true,
+	"restart-timeout": true, "scenario": true, "seed": true, "shuffle": true,
+	"start-stagger": true, "stats-compact": true, "stats-log-every": true,
+	"stats-log-memory-threshold": true, "stats-log-pids-threshold": true,
+	"stats-poll-interval": true, "stats-sample-every": true,
+	"stats-stall-factor": true, "stop-containers": true, "stop-mode": true,
+	"stop-timeout": true, "stream-stats": true, "tmpfs": true,
+	"top-interval": true, "top-leak-threshold": true, "tty": true,
+	"verbose": true, "verify-interval": true, "volume-ops": true,
+	"workload-probe-interval": true, "dry-run": true, "no-build": true,
+	"force-rebuild": true, "strict-version-check": true,
+	"throttle-max-wait": true, "throttle-retries": true,
+	"daemon-restart-poll": true, "daemon-proc-interval": true,
+	"daemon-pprof": true, "host-load-action": true, "host-load-interval": true,
+	"max-host-load": true, "min-host-memory": true,
+}
+
+// checkAPIFlags refuses flags a request to the control API may not set.
+func checkAPIFlags(flags map[string]string) error {
+	for name := range flags {
+		if !apiFlags[name] {
+			return fmt.Errorf("flag %q can't be set through the control API", name)
+		}
+	}
+	return nil
+}
+
+// runManager starts runs and keeps track of them. Each run is its own
+// process, so that runs keep the process wide state they're written
+// around and a wedged run can't take the server with it.
+type runManager struct {
+	exe string
+	// window is how many of the latest scheduled runs the rolling
+	// repro rate is over.
+	window int
+
+	mu   sync.Mutex
+	runs map[string]*managedRun
+	ids  []string
+	// outcomes of the latest scheduled runs, oldest first.
+	outcomes []string
+}
+
+func newRunManager(window int) (*runManager, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	return &runManager{exe: exe, window: window, runs: map[string]*managedRun{}}, nil
+}
+
+// start starts a run with flags, which must be run flags. Scheduled runs
+// count towards the rolling repro rate.
+func (m *runManager) start(flags map[string]string, scheduled bool) (*managedRun, error) {
+	id := newRunID()
+	// Logs are JSON so that progress can be streamed as such.
+	args := []string{"--run-id=" + id, "--log-format=json"}
+	for name, value := range flags {
+		if name == "run-id" || name == "log-format" || flag.Lookup(name) == nil {
+			return nil, fmt.Errorf("unknown flag %q", name)
+		}
+		args = append(args, fmt.Sprintf("--%s=%s", name, value))
+	}
+
+	dir := filepath.Join(runsDir, id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	out, err := os.Create(filepath.Join(dir, "output.log"))
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(m.exe, args...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Start(); err != nil {
+		out.Close()
+		return nil, err
+	}
+
+	run := &managedRun{
+		RunID:     id,
+		Flags:     flags,
+		Status:    runRunning,
+		Start:     time.Now(),
+		Scheduled: scheduled,
+		cmd:       cmd,
+		done:      make(chan struct{}),
+	}
+	m.mu.Lock()
+	m.runs[id] = run
+	m.ids = append(m.ids, id)
+	m.mu.Unlock()
+	logger.WithField("managed_run_id", id).Info("Started run")
+
+	goSafe(func() {
+		cmd.Wait()
+		out.Close()
+		m.finished(run)
+	})
+	return run, nil
+}
+
+func (m *runManager) finished(run *managedRun) {
+	var res runResult
+	readErr := readJSON(filepath.Join(runsDir, run.RunID, "results.json"), &res)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer close(run.done)
+	end := time.Now()
+	code := run.cmd.ProcessState.Sys().(syscall.WaitStatus).ExitStatus()
+	run.Status = runFinished
+	run.End = &end
+	run.ExitCode = &code
+	if readErr == nil {
+		run.Verdict = res.Verdict
+	}
+	rlog := logger.WithFields(logrus.Fields{
+		"managed_run_id": run.RunID,
+		"exit_code":      code,
+	})
+	if !run.Scheduled {
+		rlog.Info("Run finished")
+		return
+	}
+	m.outcomes = append(m.outcomes, outcome(code))
+	if len(m.outcomes) > m.window {
+		m.outcomes = m.outcomes[len(m.outcomes)-m.window:]
+	}
+	stats := m.rollingStats()
+	rlog.WithFields(logrus.Fields{
+		"window_runs": stats.runs,
+		"repro_rate":  stats.reproRate(),
+	}).Info("Scheduled run finished")
+}
+
+// rollingStats tallies the outcomes of the latest scheduled runs. The
+// caller holds m.mu.
+func (m *runManager) rollingStats() historyStats {
+	var stats historyStats
+	for _, o := range m.outcomes {
+		stats.add(o)
+	}
+	return stats
+}
+
+// rollingStatus is the body of GET /stats.
+type rollingStatus struct {
+	Window       int    `json:"window"`
+	Runs         int    `json:"runs"`
+	Pass         int    `json:"pass"`
+	Fail         int    `json:"fail"`
+	Inconclusive int    `json:"inconclusive"`
+	Other        int    `json:"other"`
+	ReproRate    string `json:"repro_rate"`
+}
+
+func (m *runManager) rollingStatus() rollingStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.rollingStats()
+	return rollingStatus{
+		Window:       m.window,
+		Runs:         s.runs,
+		Pass:         s.pass,
+		Fail:         s.fail,
+		Inconclusive: s.inconclusive,
+		Other:        s.other,
+		ReproRate:    s.reproRate(),
+	}
+}
+
+// schedule starts a run with flags every interval, skipping a turn while
+// the previous scheduled run is still going.
+func (m *runManager) schedule(interval time.Duration, flags map[string]string) {
+	var last string
+	for {
+		if run, ok := m.get(last); ok && run.Status == runRunning {
+			logger.WithField("managed_run_id", last).Warn("Previous scheduled run still running, skipping")
+		} else if run, err := m.start(flags, true); err != nil {
+			logger.WithError(err).Error("Could not start scheduled run")
+		} else {
+			last = run.RunID
+		}
+		time.Sleep(interval)
+	}
+}
+
+// get returns a copy of the run with id, safe to encode.
+func (m *runManager) get(id string) (managedRun, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	run, ok := m.runs[id]
+	if !ok {
+		return managedRun{}, false
+	}
+	return *run, true
+}
+
+func (m *runManager) list() []managedRun {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	runs := make([]managedRun, 0, len(m.ids))
+	for _, id := range m.ids {
+		runs = append(runs, *m.runs[id])
+	}
+	return runs
+}
+
+// cancel interrupts a running run, which tears it down as it would on
+// Ctrl-C.
+func (m *runManager) cancel(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	run, ok := m.runs[id]
+	if !ok {
+		return fmt.Errorf("no run %s", id)
+	}
+	if run.Status != runRunning {
+		return fmt.Errorf("run %s already finished", id)
+	}
+	return run.cmd.Process.Signal(syscall.SIGTERM)
+}
+
+// tokenAuth only lets requests that carry the token through to next.
+type tokenAuth struct {
+	token string
+	next  http.Handler
+}
+
+func (a *tokenAuth) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !validToken(req.Header.Get("Authorization"), a.token) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	a.next.ServeHTTP(w, req)
+}
+
+// validToken reports whether the authorization header value auth
+// carries token.
+func validToken(auth, token string) bool {
+	got := strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// loopbackAddr reports whether addr only listens on the loopback
+// interface.
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ServeHTTP serves the control API:
+//
+//	POST   /runs              start a run, the body is a runRequest
+//	GET    /runs              list runs
+//	GET    /runs/<id>         a run's status
+//	GET    /runs/<id>/results a finished run's results.json
+//	GET    /runs/<id>/progress a run's progress, streamed as NDJSON
+//	DELETE /runs/<id>         cancel a run
+//	GET    /stats             the repro rate over the latest scheduled runs
+func (m *runManager) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) == 1 && parts[0] == "stats" && req.Method == "GET" {
+		writeJSON(w, http.StatusOK, m.rollingStatus())
+		return
+	}
+	if parts[0] != "runs" || len(parts) > 3 {
+		http.NotFound(w, req)
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && req.Method == "POST":
+		var body runRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := checkAPIFlags(body.Flags); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		run, err := m.start(body.Flags, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		started, _ := m.get(run.RunID)
+		writeJSON(w, http.StatusCreated, started)
+
+	case len(parts) == 1 && req.Method == "GET":
+		writeJSON(w, http.StatusOK, m.list())
+
+	case len(parts) == 2 && req.Method == "GET":
+		run, ok := m.get(parts[1])
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		writeJSON(w, http.StatusOK, run)
+
+	case len(parts) == 2 && req.Method == "DELETE":
+		if err := m.cancel(parts[1]); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+
+	case len(parts) == 3 && parts[2] == "progress" && req.Method == "GET":
+		if _, ok := m.get(parts[1]); !ok {
+			http.NotFound(w, req)
+			return
+		}
+		m.streamProgress(w, req, parts[1])
+
+	case len(parts) == 3 && parts[2] == "results" && req.Method == "GET":
+		run, ok := m.get(parts[1])
+		if !ok || run.Status != runFinished {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		http.ServeFile(w, req, filepath.Join(runsDir, run.RunID, "results.json"))
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// serveCommand serves the control API, for automation to drive runs on
+// this host, and with -every runs the scenario on a schedule as a
+// canary. Arguments after the serve flags are the flags of scheduled
+// runs.
+func serveCommand(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8079", "Address to serve the control API on")
+	every := fs.Duration("every", 0, "Start a run this often (0 only starts runs on request)")
+	window := fs.Int("window", 48, "Number of latest scheduled runs the rolling repro rate is over")
+	grpcAddr := fs.String("grpc-addr", "", "Address to also serve the control API on over gRPC, as described by control/control.proto (default none)")
+	token := fs.String("token", os.Getenv("HEALTH_STATS_REPRO_TOKEN"), "Bearer token requests must carry, required to serve on other than a loopback address (default $HEALTH_STATS_REPRO_TOKEN)")
+	fs.Parse(args)
+
+	// Runs are started with run flags, register them to check
+	// requests and the scheduled run flags against.
+	registerFlags()
+	logger = logrus.NewEntry(logrus.StandardLogger())
+	flag.CommandLine.Parse(fs.Args())
+	scheduled := map[string]string{}
+	flag.Visit(func(f *flag.Flag) {
+		scheduled[f.Name] = f.Value.String()
+	})
+
+	m, err := newRunManager(*window)
+	if err != nil {
+		logger.WithError(err).Fatal("Could not find own executable")
+	}
+	if *every > 0 {
+		logger.WithField("every", *every).Info("Scheduling runs")
+		goSafe(func() {
+			m.schedule(*every, scheduled)
+		})
+	}
+	var handler http.Handler = m
+	if *token != "" {
+		handler = &tokenAuth{token: *token, next: m}
+	} else if !loopbackAddr(*addr) {
+		logger.WithField("addr", *addr).Fatal("Serving the control API on other than a loopback address needs a -token")
+	}
+	if *grpcAddr != "" {
+		if *token == "" && !loopbackAddr(*grpcAddr) {
+			logger.WithField("grpc_addr", *grpcAddr).Fatal("Serving the control API on other than a loopback address needs a -token")
+		}
+		lis, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			logger.WithError(err).Fatal("Could not listen for gRPC")
+		}
+		logger.WithField("grpc_addr", *grpcAddr).Info("Serving control API over gRPC")
+		goSafe(func() {
+			logger.WithError(serveGRPC(lis, m, *token)).Fatal("gRPC server failed")
+		})
+	}
+	logger.WithField("addr", *addr).Info("Serving control API")
+	logger.Fatal(http.ListenAndServe(*addr, handler))
+}
+
+// progressEvent is a line of a run's progress stream: a log entry of the
+// run, a line of its other output, or, last, its final status.
+type progressEvent struct {
+	Type   string          `json:"type"`
+	Log    json.RawMessage `json:"log,omitempty"`
+	Output string          `json:"output,omitempty"`
+	Run    *managedRun     `json:"run,omitempty"`
+}
+
+const progressPoll = 250 * time.Millisecond
+
+// streamProgress streams the run's progress as NDJSON until it finishes
+// or the client goes away.
+func (m *runManager) streamProgress(w http.ResponseWriter, req *http.Request, id string) {
+	if _, err := os.Stat(progressPath(id)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	m.followProgress(req.Context(), id, func(ev progressEvent) error {
+		return enc.Encode(ev)
+	}, func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	})
+}
+
+// progressPath is where the output of the run with id is written.
+func progressPath(id string) string {
+	return filepath.Join(runsDir, id, "output.log")
+}
+
+// followProgress passes the run's progress to send until the run
+// finished, ctx is done or send fails. flush, if not nil, is called
+// whenever the run's output has been caught up with.
+func (m *runManager) followProgress(ctx context.Context, id string, send func(progressEvent) error, flush func()) error {
+	f, err := os.Open(progressPath(id))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		// Check before reading, so that the output is drained once
+		// the run has finished.
+		run, _ := m.get(id)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				// Partial lines are picked up once complete.
+				f.Seek(-int64(len(line)), io.SeekCurrent)
+				r.Reset(f)
+				break
+			}
+			line = strings.TrimSuffix(line, "\n")
+			ev := progressEvent{Type: "output", Output: line}
+			if json.Valid([]byte(line)) {
+				ev = progressEvent{Type: "log", Log: json.RawMessage(line)}
+			}
+			if err := send(ev); err != nil {
+				return err
+			}
+		}
+		if run.Status == runFinished {
+			return send(progressEvent{Type: "status", Run: &run})
+		}
+		if flush != nil {
+			flush()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(progressPoll):
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+const eventBacklogSize = 500
+
+// eventBacklog keeps the most recent daemon events so that they can be
+// included in a snapshot taken after the fact, and writes every event
+// to the run's events log.
+type eventBacklog struct {
+	listener chan *docker.APIEvents
+
+	mu      sync.Mutex
+	events  []*docker.APIEvents
+	out     io.WriteCloser
+	stopped bool
+}
+
+func (b *eventBacklog) add(event *docker.APIEvents) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.out != nil && eventFilters.match(event) {
+		if err := json.NewEncoder(b.out).Encode(event); err != nil {
+			logger.WithError(err).Warn("Could not write event to events log")
+		}
+	}
+	b.events = append(b.events, event)
+	if len(b.events) > eventBacklogSize {
+		b.events = b.events[len(b.events)-eventBacklogSize:]
+	}
+}
+
+func (b *eventBacklog) recent() []*docker.APIEvents {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]*docker.APIEvents(nil), b.events...)
+}
+
+// watchEvents subscribes to the daemon's event stream and records it
+// into a backlog, and to out, for the remainder of the run. When the
+// stream drops, it reconnects and replays the events it missed.
+func watchEvents(client *docker.Client, out io.WriteCloser) (*eventBacklog, error) {
+	events := make(chan *docker.APIEvents, 64)
+	if err := client.AddEventListener(events); err != nil {
+		return nil, err
+	}
+	backlog := &eventBacklog{listener: events, out: out}
+	goSafe(func() {
+		var last int64
+		for {
+			for event := range events {
+				if event.TimeNano != 0 && event.TimeNano <= last {
+					// Replayed already.
+					continue
+				}
+				last = event.TimeNano
+				backlog.handle(event)
+			}
+			if events = backlog.reconnect(client, &last); events == nil {
+				return
+			}
+		}
+	})
+	return backlog, nil
+}
+
+// handle records event in the backlog and the run's results.
+func (b *eventBacklog) handle(event *docker.APIEvents) {
+	b.add(event)
+	if status := strings.TrimPrefix(event.Action, "health_status: "); status != event.Action {
+		results.recordHealth(event.Actor.ID, status)
+		results.recordHealthEvent(event.Actor.ID, status, time.Unix(0, event.TimeNano))
+	}
+	if isHealthcheckProbe(event.Action) {
+		results.recordHealthProbe(event.Actor.ID, time.Unix(0, event.TimeNano))
+	}
+	if state, ok := eventStates[event.Action]; ok && event.Type == "container" {
+		results.recordState(event.Actor.ID, state)
+		// A started container's health is "starting" until the
+		// first check ran, which has no event of its own.
+		if event.Action == "start" && useHealthchecks && !results.isControl(event.Actor.ID) {
+			results.recordHealthEvent(event.Actor.ID, "starting", time.Unix(0, event.TimeNano))
+		}
+	}
+}
+
+// stop unsubscribes the backlog from the event stream, keeping what it
+// has recorded so far, and closes its events log.
+func (b *eventBacklog) stop(client *docker.Client) {
+	b.mu.Lock()
+	b.stopped = true
+	listener := b.listener
+	b.mu.Unlock()
+	if err := client.RemoveEventListener(listener); err != nil {
+		logger.WithError(err).Warn("Could not stop listening for events")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.out.Close()
+	b.out = nil
+}
+
+// systemSnapshot approximates what an operator would have seen running
+// `docker ps`, `docker images`, `docker info` and `docker events` on the
+// host at the moment a detector fired.
+type systemSnapshot struct {
+	RunID      string              `json:"run_id"`
+	Time       time.Time           `json:"time"`
+	Reason     string              `json:"reason"`
+	Containers []snapshotContainer `json:"containers"`
+	Images     []docker.APIImages  `json:"images"`
+	Info       *docker.DockerInfo  `json:"info"`
+	Events     []*docker.APIEvents `json:"events"`
+	// Errors holds the calls that failed or timed out while the
+	// snapshot was taken, by call name.
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+type snapshotContainer struct {
+	ID      string   `json:"id"`
+	Names   []string `json:"names"`
+	Image   string   `json:"image"`
+	Command string   `json:"command"`
+	Created int64    `json:"created"`
+	State   string   `json:"state"`
+	Status  string   `json:"status"`
+	Health  string   `json:"health"`
+}
+
+var snapshotTaken sync.Once
+
+// snapshotOnDetection captures and writes a system snapshot the first
+// time a detector fires during the run. Later detections are skipped,
+// the first snapshot already shows the daemon in its wedged state.
+func snapshotOnDetection(client *docker.Client, events *eventBacklog, reason string) {
+	snapshotTaken.Do(func() {
+		snap := captureSnapshot(client, events, reason)
+		path, err := writeSnapshot(snap)
+		if err != nil {
+			logger.WithError(err).Error("Could not write system snapshot")
+			return
+		}
+		logger.WithField("path", path).Info("Wrote system snapshot")
+	})
+}
+
+func captureSnapshot(client *docker.Client, events *eventBacklog, reason string) systemSnapshot {
+	timeout := time.Duration(callTimeoutSecs) * time.Second
+	snap := systemSnapshot{
+		RunID:  runID,
+		Time:   time.Now(),
+		Reason: reason,
+		Events: events.recent(),
+		Errors: map[string]string{},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	conts, err := client.ListContainers(docker.ListContainersOptions{All: true, Context: ctx})
+	cancel()
+	if err != nil {
+		snap.Errors["containers"] = err.Error()
+	}
+	for _, c := range conts {
+		snap.Containers = append(snap.Containers, snapshotContainer{
+			ID:      c.ID,
+			Names:   c.Names,
+			Image:   c.Image,
+			Command: c.Command,
+			Created: c.Created,
+			State:   c.State,
+			Status:  c.Status,
+			Health:  healthFromStatus(c.Status),
+		})
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	snap.Images, err = client.ListImages(docker.ListImagesOptions{Context: ctx})
+	cancel()
+	if err != nil {
+		snap.Errors["images"] = err.Error()
+	}
+
+	// Info doesn't take a context, so bound it by abandoning the call.
+	var info *docker.DockerInfo
+	err = callWithTimeout(timeout, func() (err error) {
+		info, err = client.Info()
+		return err
+	})
+	if err != nil {
+		snap.Errors["info"] = err.Error()
+	} else {
+		snap.Info = info
+	}
+
+	return snap
+}
+
+func writeSnapshot(snap systemSnapshot) (string, error) {
+	name := runPath(fmt.Sprintf("snapshot-%s.json", snap.Time.Format(time.RFC3339)))
+	f, err := os.Create(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return name, enc.Encode(snap)
+}
+
+// healthFromStatus extracts the health column the docker CLI shows
+// from a container's status line, eg. "Up 3 minutes (healthy)".
+func healthFromStatus(status string) string {
+	for _, health := range []string{"health: starting", "unhealthy", "healthy"} {
+		if strings.HasSuffix(status, "("+health+")") {
+			return strings.TrimPrefix(health, "health: ")
+		}
+	}
+	return ""
+}
+
+// callWithTimeout runs fn and gives up waiting on it after timeout,
+// for client calls that don't accept a context. The call is left to
+// finish, or hang, in the background.
+func callWithTimeout(timeout time.Duration, fn func() error) error {
+	done := make(chan error, 1)
+	goSafe(func() {
+		done <- fn()
+	})
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return &callTimeoutError{timeout: timeout}
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+// soakCommand runs one run after the other, each as its own process
+// with the run flags given after its own, and estimates the repro
+// probability of a run with a confidence interval as it goes. It stops
+// once the interval is narrow enough, or after a number of runs.
+func soakCommand(args []string) {
+	fs := flag.NewFlagSet("soak", flag.ExitOnError)
+	minIterations := fs.Int("min-iterations", 10, "Conclusive runs to make before the target confidence can stop the soak")
+	maxIterations := fs.Int("max-iterations", 0, "Stop after this many runs (0 runs until stopped)")
+	targetConfidence := fs.Float64("target-confidence", 0.95, "Confidence level of the repro probability's interval (0 never stops early)")
+	margin := fs.Float64("margin", 0.05, "Stop once the interval is at most this far from the estimate on either side")
+	fs.Parse(args)
+
+	registerFlags()
+	logger = logrus.NewEntry(logrus.StandardLogger())
+	flag.CommandLine.Parse(fs.Args())
+	runFlags := map[string]string{}
+	flag.Visit(func(f *flag.Flag) {
+		runFlags[f.Name] = f.Value.String()
+	})
+
+	conf := *targetConfidence
+	if conf <= 0 || conf >= 1 {
+		conf = 0.95
+	}
+	m, err := newRunManager(int(^uint(0) >> 1))
+	if err != nil {
+		logger.WithError(err).Fatal("Could not find own executable")
+	}
+
+	// An interrupt reaches the run in progress too, the soak stops once
+	// it has finished.
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	for i := 1; *maxIterations == 0 || i <= *maxIterations; i++ {
+		run, err := m.start(runFlags, true)
+		if err != nil {
+			logger.WithError(err).Fatal("Could not start run")
+		}
+		<-run.done
+
+		m.mu.Lock()
+		stats := m.rollingStats()
+		m.mu.Unlock()
+		lo, hi := stats.reproInterval(conf)
+		fmt.Printf("Run %d %s: %s, repro rate %s (%.0f%% CI %s) over %d conclusive run(s)\n",
+			i, run.RunID, outcome(*run.ExitCode), stats.reproRate(), 100*conf, stats.formatInterval(conf), stats.pass+stats.fail)
+
+		select {
+		case <-stop:
+			fmt.Println("Soak interrupted")
+			return
+		default:
+		}
+		p := float64(stats.fail) / float64(stats.pass+stats.fail)
+		if *targetConfidence > 0 && stats.pass+stats.fail >= *minIterations && p-lo <= *margin && hi-p <= *margin {
+			fmt.Printf("Repro probability is within %.1f%% at %.0f%% confidence\n", 100**margin, 100*conf)
+			return
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/pkg/errors"
+)
+
+// statsStallFactor is how many stats intervals a container's stats may
+// go without a sample before its stream counts as stalled. A stream
+// that froze silently looks like a quiet one otherwise.
+var statsStallFactor float64
+
+// errHungWhileWaiting is the error of a container that was found hung
+// while the run waited, before it was checked.
+var errHungWhileWaiting = errors.New("hung while the run waited")
+
+// daemonStatsInterval is how often the daemon sends a sample on a stats
+// stream.
+const daemonStatsInterval = time.Second
+
+// lastStatsSample returns when the last stats sample of the container
+// arrived, zero if none did yet.
+func (r *runResult) lastStatsSample(id string) time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c := r.container(id); c != nil {
+		return c.LastStatsSample
+	}
+	return time.Time{}
+}
+
+// watchStatsStalls checks the stats of conts until ctx is done, and
+// treats a container whose stats stop arriving for factor intervals as
+// hung.
+func watchStatsStalls(ctx context.Context, client *docker.Client, conts []*docker.Container, factor float64) {
+	interval := daemonStatsInterval
+	if statsPollInterval > 0 {
+		interval = statsPollInterval
+	}
+	stallAfter := time.Duration(factor * float64(interval))
+	started := time.Now()
+	for {
+		if err := sleepCtx(ctx, interval); err != nil {
+			return
+		}
+		for _, cont := range conts {
+			if isAffected(cont.ID) {
+				continue
+			}
+			last := results.lastStatsSample(cont.ID)
+			if last.IsZero() {
+				last = started
+			}
+			if quiet := time.Since(last); quiet > stallAfter {
+				hangDetected(client, cont, "stats", errors.Errorf("no stats sample for %s", quiet.Truncate(time.Millisecond)))
+			}
+		}
+	}
+}
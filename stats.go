@@ -0,0 +1,176 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// statsSampleEvery writes every Nth stats sample of a container to
+	// the stats data file. All samples still count as a sign of life.
+	statsSampleEvery int
+	// statsCompact writes compactStats rather than the raw samples.
+	statsCompact bool
+)
+
+// statsRecord is a line of the run's stats log.
+type statsRecord struct {
+	Time        time.Time     `json:"time"`
+	ContainerID string        `json:"container_id"`
+	Stats       *docker.Stats `json:"stats,omitempty"`
+	Compact     *compactStats `json:"compact,omitempty"`
+}
+
+// compactStats is a stats sample reduced to the figures `docker stats`
+// shows, which is what is worth keeping and reading over a long soak.
+type compactStats struct {
+	Read          time.Time `json:"read"`
+	CPUPercent    float64   `json:"cpu_percent"`
+	MemoryUsage   uint64    `json:"memory_usage"`
+	MemoryLimit   uint64    `json:"memory_limit"`
+	MemoryPercent float64   `json:"memory_percent"`
+	Pids          uint64    `json:"pids"`
+}
+
+// newStatsRecord returns the stats log line of a sample of the
+// container.
+func newStatsRecord(id string, stat *docker.Stats) statsRecord {
+	rec := statsRecord{Time: time.Now(), ContainerID: id}
+	if !statsCompact {
+		rec.Stats = stat
+		return rec
+	}
+	rec.Compact = &compactStats{
+		Read:          stat.Read,
+		CPUPercent:    cpuPercent(stat),
+		MemoryUsage:   memoryUsage(stat),
+		MemoryLimit:   stat.MemoryStats.Limit,
+		MemoryPercent: memoryPercent(stat),
+		Pids:          stat.PidsStats.Current,
+	}
+	return rec
+}
+
+// statsBufferSize bounds the stats samples waiting to be written to the
+// stats data file. A slow writer then drops samples rather than holding
+// up the readers of the containers' streams, which could cause stalls
+// of their own or hide real ones.
+const statsBufferSize = 1024
+
+// sendDropOldest sends rec on records, dropping the oldest buffered
+// record when it is full. The dropped record is counted against its
+// container.
+func sendDropOldest(records chan statsRecord, rec statsRecord) {
+	for {
+		select {
+		case records <- rec:
+			return
+		default:
+		}
+		select {
+		case old := <-records:
+			if results.recordStatsDropped(old.ContainerID) {
+				logger.WithField("container_id", old.ContainerID).Warn("Dropping stats samples, the stats file can't keep up")
+			}
+		default:
+		}
+	}
+}
+
+// statsSampler decides which of a container's stats samples make it
+// into the human log. Every sample is still written to the stats data
+// file regardless.
+type statsSampler struct {
+	// every logs every Nth sample, zero disables periodic logging.
+	every int
+	// memoryThreshold and pidsThreshold log the samples where memory
+	// usage or the pids count cross the threshold, in either
+	// direction. Zero disables the threshold.
+	memoryThreshold uint64
+	pidsThreshold   uint64
+
+	n     int
+	above bool
+}
+
+func (s *statsSampler) sample(stat *docker.Stats) bool {
+	s.n++
+	above := (s.memoryThreshold > 0 && stat.MemoryStats.Usage >= s.memoryThreshold) ||
+		(s.pidsThreshold > 0 && stat.PidsStats.Current >= s.pidsThreshold)
+	crossed := above != s.above
+	s.above = above
+	return crossed || (s.every > 0 && (s.n-1)%s.every == 0)
+}
+
+func statsFields(stat *docker.Stats) logrus.Fields {
+	return logrus.Fields{
+		"read":           stat.Read,
+		"cpu_percent":    cpuPercent(stat),
+		"memory_usage":   stat.MemoryStats.Usage,
+		"memory_limit":   stat.MemoryStats.Limit,
+		"memory_percent": memoryPercent(stat),
+		"pids":           stat.PidsStats.Current,
+	}
+}
+
+// statsPollInterval, when set, has the containers' stats polled with
+// one-shot requests instead of streamed. The daemon serves those on a
+// path of their own, which may or may not hang with the stream.
+var statsPollInterval time.Duration
+
+// pollStats requests one-shot stats of cont every interval and sends
+// them to out, until ctx is done or a request hangs. It closes out when
+// it returns, like a stats stream ending.
+func pollStats(ctx context.Context, client *docker.Client, cont *docker.Container, out chan<- *docker.Stats, interval time.Duration) {
+	defer close(out)
+	callTimeout := time.Duration(callTimeoutSecs) * time.Second
+	for {
+		if err := sleepCtx(ctx, interval); err != nil {
+			return
+		}
+		stats := make(chan *docker.Stats, 1)
+		olog, err := timedOp(client, cont, "stats-oneshot", callTimeout, func(ctx context.Context) error {
+			return client.Stats(docker.StatsOptions{
+				Context: ctx,
+				ID:      cont.ID,
+				Stats:   stats,
+				Stream:  false,
+			})
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if classifyError(err) == errClassTimeout {
+			return
+		}
+		if err != nil {
+			olog.Warn("Could not get container stats")
+			continue
+		}
+		if stat, ok := <-stats; ok {
+			select {
+			case out <- stat:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// cpuPercent returns the CPU usage of a stats sample in percent of one
+// CPU, the way `docker stats` computes it: the container's share of the
+// host's CPU time since the previous sample, times the number of CPUs.
+// It is zero when the sample carries no previous one, as one-shot
+// samples of older daemons don't.
+func cpuPercent(stat *docker.Stats) float64 {
+	cpu, pre := stat.CPUStats, stat.PreCPUStats
+	if cpu.CPUUsage.TotalUsage < pre.CPUUsage.TotalUsage || cpu.SystemCPUUsage <= pre.SystemCPUUsage {
+		return 0
+	}
+	cpus := cpu.OnlineCPUs
+	if cpus == 0 {
+		cpus = uint64(len(cpu.CPUUsage.PercpuUsage))
+	}
+	cpuDelta := float64(cpu.CPUUsage.TotalUsage - pre.CPUUsage.TotalUsage)
+	systemDelta := float64(cpu.SystemCPUUsage - pre.SystemCPUUsage)
+	return cpuDelta / systemDelta * float64(cpus) * 100
+}
+
+// memoryUsage returns the memory usage of a stats sample without the
+// page cache, like `docker stats` does.
+func memoryUsage(stat *docker.Stats) uint64 {
+	usage, cache := stat.MemoryStats.Usage, stat.MemoryStats.Stats.Cache
+	if cache > usage {
+		return 0
+	}
+	return usage - cache
+}
+
+// memoryPercent returns memoryUsage in percent of the container's
+// memory limit.
+func memoryPercent(stat *docker.Stats) float64 {
+	if stat.MemoryStats.Limit == 0 {
+		return 0
+	}
+	return float64(memoryUsage(stat)) / float64(stat.MemoryStats.Limit) * 100
+}
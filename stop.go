@@ -0,0 +1,174 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/sirupsen/logrus"
+)
+
+// How the containers are stopped once the run is over. The daemon tears
+// a container's healthcheck down differently when it is stopped
+// gracefully than when it is killed.
+const (
+	stopModeKill = "kill"
+	stopModeStop = "stop"
+	// stopModeEscalate stops a container, kills it if that fails and
+	// force removes it if that fails too, the way the ECS agent tears
+	// down stuck containers.
+	stopModeEscalate = "escalate"
+)
+
+// Steps of an escalation, in order.
+const (
+	escalateStop        = "stop"
+	escalateKill        = "kill"
+	escalateForceRemove = "force-remove"
+)
+
+var (
+	stopMode    string
+	stopTimeout uint
+	killSignal  string
+
+	// killSignalNumber is killSignal, parsed.
+	killSignalNumber docker.Signal
+
+	// escalationTimeoutsSpec holds the timeouts of the stop, kill and
+	// force remove steps of an escalation, comma separated.
+	escalationTimeoutsSpec string
+	escalationTimeouts     []time.Duration
+
+	// containerStopSignal and containerStopTimeout are set on the
+	// containers' config. The daemon sends the stop signal on a
+	// graceful stop, before it kills the container, and waits the stop
+	// timeout for it whenever the caller doesn't say how long to wait.
+	containerStopSignal  string
+	containerStopTimeout uint
+)
+
+// parseEscalationTimeouts parses the timeouts of the three steps of an
+// escalation. Without any, each step gets timeout, and the stop its
+// --stop-timeout on top.
+func parseEscalationTimeouts(spec string, timeout time.Duration) ([]time.Duration, error) {
+	if spec == "" {
+		return []time.Duration{timeout + time.Duration(stopTimeout)*time.Second, timeout, timeout}, nil
+	}
+	parts := strings.Split(spec, ",")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("escalation timeouts %q aren't three timeouts, of the stop, kill and force remove", spec)
+	}
+	var timeouts []time.Duration
+	for _, p := range parts {
+		d, err := time.ParseDuration(strings.TrimSpace(p))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("bad escalation timeout %q", p)
+		}
+		timeouts = append(timeouts, d)
+	}
+	return timeouts, nil
+}
+
+var signalNames = map[string]docker.Signal{
+	"HUP":  docker.SIGHUP,
+	"INT":  docker.SIGINT,
+	"QUIT": docker.SIGQUIT,
+	"KILL": docker.SIGKILL,
+	"USR1": docker.SIGUSR1,
+	"USR2": docker.SIGUSR2,
+	"TERM": docker.SIGTERM,
+}
+
+// parseSignal parses a signal given by name, with or without SIG, or by
+// number.
+func parseSignal(s string) (docker.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil && n > 0 {
+		return docker.Signal(n), nil
+	}
+	if sig, ok := signalNames[strings.TrimPrefix(strings.ToUpper(s), "SIG")]; ok {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("unknown signal %q", s)
+}
+
+// stopContainer stops cont as --stop-mode says, bounded by timeout. A
+// graceful stop may take --stop-timeout on top of it before the daemon
+// kills the container.
+func stopContainer(client *docker.Client, cont *docker.Container, timeout time.Duration) error {
+	var fn func(ctx context.Context) error
+	switch stopMode {
+	case stopModeStop:
+		timeout += time.Duration(stopTimeout) * time.Second
+		fn = func(ctx context.Context) error {
+			return client.StopContainerWithContext(cont.ID, stopTimeout, ctx)
+		}
+	default:
+		fn = func(ctx context.Context) error {
+			return client.KillContainer(docker.KillContainerOptions{
+				Context: ctx,
+				ID:      cont.ID,
+				Signal:  killSignalNumber,
+			})
+		}
+	}
+	olog, err := timedOp(client, cont, stopMode, timeout, fn)
+	failFastOnHang(cont, stopMode, err)
+	if err != nil {
+		olog.Warn("Could not stop container, will try to inspect it")
+		return err
+	}
+	olog.Debug("Stopped container")
+	return nil
+}
+
+// escalate stops cont, escalating to a kill and a force remove for as
+// long as a step fails or hangs. It returns the step that succeeded,
+// recorded in the results, or the error of the last one.
+func escalate(client *docker.Client, cont *docker.Container) (string, error) {
+	steps := []struct {
+		name string
+		fn   func(ctx context.Context) error
+	}{
+		{escalateStop, func(ctx context.Context) error {
+			return client.StopContainerWithContext(cont.ID, stopTimeout, ctx)
+		}},
+		{escalateKill, func(ctx context.Context) error {
+			return client.KillContainer(docker.KillContainerOptions{Context: ctx, ID: cont.ID})
+		}},
+		{escalateForceRemove, func(ctx context.Context) error {
+			return client.RemoveContainer(docker.RemoveContainerOptions{Context: ctx, ID: cont.ID, Force: true})
+		}},
+	}
+	var err error
+	for i, step := range steps {
+		var olog *logrus.Entry
+		olog, err = timedOp(client, cont, step.name, escalationTimeouts[i], step.fn)
+		failFastOnHang(cont, step.name, err)
+		if err == nil {
+			olog.Debug("Stopped container")
+			results.recordStoppedBy(cont.ID, step.name)
+			return step.name, nil
+		}
+		olog.Warn("Could not stop container, escalating")
+	}
+	return "", err
+}
@@ -0,0 +1,244 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/sirupsen/logrus"
+)
+
+// teardownPhase orders the steps of tearing a run down so that nothing
+// is removed while something else still depends on it.
+type teardownPhase int
+
+const (
+	phaseStreams teardownPhase = iota
+	phaseKill
+	phaseRemove
+	phaseNetworksVolumes
+	phaseImages
+	numTeardownPhases
+)
+
+var teardownPhaseNames = [numTeardownPhases]string{
+	"stop-streams",
+	"kill-containers",
+	"remove-containers",
+	"remove-networks-volumes",
+	"remove-images",
+}
+
+func (p teardownPhase) String() string {
+	return teardownPhaseNames[p]
+}
+
+type teardownStep struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+var (
+	// rootCtx is canceled when the run is interrupted. Calls made on
+	// behalf of the run derive their contexts from it.
+	rootCtx, cancelRun = context.WithCancel(context.Background())
+
+	cleanupOnInterrupt bool
+
+	// exitMu is held by whoever is exiting the process, so that an
+	// interrupted run isn't raced to os.Exit by the errors its own
+	// cancellation causes.
+	exitMu sync.Mutex
+	// exiting is set once exitMu is taken.
+	exiting int32
+
+	teardownMu    sync.Mutex
+	teardownSteps [numTeardownPhases][]teardownStep
+	// affectedIDs are containers that are left in place for
+	// inspection rather than removed.
+	affectedIDs = map[string]bool{}
+)
+
+// onTeardown registers a step to be run in phase when the run exits.
+// Steps are given their own timeout and must not exit themselves.
+func onTeardown(phase teardownPhase, name string, fn func(ctx context.Context) error) {
+	teardownMu.Lock()
+	defer teardownMu.Unlock()
+	teardownSteps[phase] = append(teardownSteps[phase], teardownStep{name: name, fn: fn})
+}
+
+// runTeardown runs every registered step, phase by phase. A failing or
+// hung step is logged and the teardown carries on with the next one.
+func runTeardown() {
+	teardownMu.Lock()
+	phases := teardownSteps
+	teardownSteps = [numTeardownPhases][]teardownStep{}
+	teardownMu.Unlock()
+
+	timeout := time.Duration(callTimeoutSecs) * time.Second
+	for phase, steps := range phases {
+		for _, step := range steps {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			start := time.Now()
+			err := callWithTimeout(timeout, func() error { return step.fn(ctx) })
+			cancel()
+
+			slog := logger.WithFields(logrus.Fields{
+				"teardown_phase": teardownPhase(phase),
+				"teardown_step":  step.name,
+				"duration":       time.Since(start),
+			})
+			if err != nil {
+				slog.WithError(err).Warn("Teardown step failed, continuing")
+				continue
+			}
+			slog.Debug("Teardown step done")
+		}
+	}
+}
+
+func markAffected(id string) {
+	teardownMu.Lock()
+	defer teardownMu.Unlock()
+	affectedIDs[id] = true
+}
+
+func isAffected(id string) bool {
+	teardownMu.Lock()
+	defer teardownMu.Unlock()
+	return affectedIDs[id]
+}
+
+// handleSignals tears the run down and exits when it receives SIGINT or
+// SIGTERM. A second signal exits straight away.
+func handleSignals() {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-sigs
+		exitMu.Lock()
+		atomic.StoreInt32(&exiting, 1)
+		logger.WithField("signal", sig).Warn("Interrupted, tearing down")
+
+		go func() {
+			sig := <-sigs
+			logger.WithField("signal", sig).Error("Interrupted again, exiting without finishing teardown")
+			os.Exit(signalExitCode(sig))
+		}()
+
+		cancelRun()
+		runTeardown()
+
+		code := signalExitCode(sig)
+		reportVerdict(code, fmt.Sprintf("INTERRUPTED: received %s", sig))
+		os.Exit(code)
+	}()
+}
+
+// signalExitCode follows the shell convention of 128+n for a process
+// terminated by signal n.
+func signalExitCode(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 1
+}
+
+// registerContainerTeardown makes sure cont is killed and removed, as
+// configured, however the run exits.
+func registerContainerTeardown(client *docker.Client, cont *docker.Container) {
+	onTeardown(phaseKill, "kill "+cont.ID, func(ctx context.Context) error {
+		if !teardownContainers(stopContainers) {
+			return nil
+		}
+		err := client.KillContainer(docker.KillContainerOptions{Context: ctx, ID: cont.ID})
+		switch err.(type) {
+		case *docker.ContainerNotRunning, *docker.NoSuchContainer:
+			return nil
+		}
+		return err
+	})
+	onTeardown(phaseRemove, "remove "+cont.ID, func(ctx context.Context) error {
+		if !teardownContainers(removeContainers) {
+			return nil
+		}
+		if isAffected(cont.ID) {
+			logger.WithField("container_id", cont.ID).Info("Leaving affected container in place for inspection")
+			return nil
+		}
+		err := client.RemoveContainer(docker.RemoveContainerOptions{Context: ctx, ID: cont.ID, Force: true})
+		if _, ok := err.(*docker.NoSuchContainer); ok {
+			return nil
+		}
+		return err
+	})
+}
+
+// teardownContainers reports whether a container teardown step should
+// run. When interrupted that is up to --cleanup-on-interrupt, otherwise
+// to the step's own configuration.
+func teardownContainers(configured bool) bool {
+	if rootCtx.Err() != nil {
+		return cleanupOnInterrupt
+	}
+	return configured
+}
+
+// recoverAndExit turns a panic in the calling goroutine into an orderly
+// exit: containers are torn down, the results written and the partial
+// summary printed. A panic while already exiting exits straight away.
+func recoverAndExit() {
+	v := recover()
+	if v == nil {
+		return
+	}
+	logger.WithField("stack", string(debug.Stack())).Errorf("Panic: %v", v)
+	if atomic.LoadInt32(&exiting) != 0 {
+		os.Exit(1)
+	}
+	exit(1, fmt.Sprintf("PANIC: %v", v))
+}
+
+// goSafe runs fn on a new goroutine that exits the run in an orderly
+// fashion if fn panics.
+func goSafe(fn func()) {
+	go func() {
+		defer recoverAndExit()
+		fn()
+	}()
+}
+
+// sleepCtx sleeps for d, or until ctx is done.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	topInterval      time.Duration
+	topLeakThreshold int
+)
+
+// sampleTop lists the processes of conts every interval until ctx is
+// done. Anything but the container's own process is a healthcheck or
+// exec, which normally exits within its interval, so processes piling
+// up point at healthcheck execs the daemon lost track of.
+func sampleTop(ctx context.Context, client *docker.Client, conts []*docker.Container, interval time.Duration) {
+	callTimeout := time.Duration(callTimeoutSecs) * time.Second
+	for {
+		if err := sleepCtx(ctx, interval); err != nil {
+			return
+		}
+		for _, cont := range conts {
+			if isAffected(cont.ID) {
+				continue
+			}
+			var top docker.TopResult
+			start := time.Now()
+			err := watchCall(ctx, cont.ID, "top", callTimeout, func(context.Context) (err error) {
+				top, err = client.TopContainer(cont.ID, "")
+				return err
+			})
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				olog := finishOp(logger.WithField("container_id", cont.ID), cont.ID, "top", start, err)
+				if classifyError(err) == errClassTimeout {
+					hangDetected(client, cont, "top", err)
+				}
+				olog.Warn("Could not list container processes")
+				continue
+			}
+			extra := len(top.Processes) - ownProcesses()
+			if extra < 0 {
+				extra = 0
+			}
+			if results.recordTop(cont.ID, extra, topLeakThreshold) {
+				logger.WithFields(logrus.Fields{
+					"container_id": cont.ID,
+					"processes":    top.Processes,
+				}).Warnf("%d healthcheck or exec processes are running in the container", extra)
+			}
+		}
+	}
+}
+
+// ownProcesses is how many processes a container runs besides
+// healthchecks and execs: its command, the shell that runs the
+// exit-restart scenario's commands one after the other and the init
+// process that reaps them with --init.
+func ownProcesses() int {
+	n := 1
+	if scenario == scenarioExitRestart {
+		n++
+	}
+	if withInit {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,59 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// verifyInterval is how often each container is inspected while the run
+// waits, so that a hang is caught, and alerted on, as it happens rather
+// than once the run is over.
+var verifyInterval time.Duration
+
+// verifyContainers inspects each of conts every interval until ctx is
+// done. Containers are verified independently of one another, so one
+// that hangs doesn't hold up the others, and aren't verified again once
+// affected.
+func verifyContainers(ctx context.Context, client *docker.Client, conts []*docker.Container, interval time.Duration) {
+	deadline := time.Now().Add(runDuration)
+	callTimeout := time.Duration(callTimeoutSecs) * time.Second
+	for _, cont := range conts {
+		cont := cont
+		goSafe(func() {
+			for !isAffected(cont.ID) {
+				if err := sleepCtx(ctx, interval); err != nil {
+					return
+				}
+				olog, err := timedOp(client, cont, "verify", callTimeout, func(ctx context.Context) error {
+					_, err := client.InspectContainerWithContext(cont.ID, ctx)
+					return err
+				})
+				switch {
+				case classifyError(err) == errClassTimeout:
+					olog.WithField("run_left", time.Until(deadline).Truncate(time.Second)).
+						Error("Container hung while the run waits, the daemon can be debugged live now")
+					return
+				case err != nil:
+					olog.Warn("Could not verify container")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Whether an engine version is known to hang.
+const (
+	versionAffected   = "known-affected"
+	versionUnaffected = "known-unaffected"
+	versionUntested   = "untested"
+)
+
+// versionRange is a range of engine versions, from inclusive and
+// before exclusive. Empty bounds are open.
+type versionRange struct {
+	from, before string
+	status       string
+	note         string
+}
+
+// knownVersions records the engine versions the hang was reproduced on,
+// or not, from the results in the README. Versions outside every range
+// haven't been tested, a result on them is new information.
+var knownVersions = []versionRange{
+	{before: "17.12.0-rc1", status: versionUnaffected, note: "17.09.1 through 17.11.0 pass"},
+	{from: "17.12.0-rc1", before: "18.03.0", status: versionAffected, note: "17.12.0-rc1 through 18.03.0-rc4 fail"},
+}
+
+var strictVersionCheck bool
+
+// engineVersion is a parsed engine version, eg. 18.03.0-ce-rc4.
+type engineVersion struct {
+	parts [3]int
+	// rc is the release candidate number, zero for a release.
+	rc int
+}
+
+func parseEngineVersion(s string) (engineVersion, error) {
+	var v engineVersion
+	s = strings.Replace(s, "~", "-", -1)
+	fields := strings.Split(s, "-")
+	nums := strings.Split(fields[0], ".")
+	if len(nums) != 3 {
+		return v, fmt.Errorf("malformed engine version %q", s)
+	}
+	for i, n := range nums {
+		var err error
+		if v.parts[i], err = strconv.Atoi(n); err != nil {
+			return v, fmt.Errorf("malformed engine version %q", s)
+		}
+	}
+	for _, f := range fields[1:] {
+		if strings.HasPrefix(f, "rc") {
+			v.rc, _ = strconv.Atoi(strings.TrimPrefix(f, "rc"))
+		}
+	}
+	return v, nil
+}
+
+// less reports whether v is older than w. Release candidates come before
+// the release.
+func (v engineVersion) less(w engineVersion) bool {
+	for i := range v.parts {
+		if v.parts[i] != w.parts[i] {
+			return v.parts[i] < w.parts[i]
+		}
+	}
+	switch {
+	case v.rc == w.rc:
+		return false
+	case v.rc == 0:
+		return false
+	case w.rc == 0:
+		return true
+	}
+	return v.rc < w.rc
+}
+
+// versionStatus looks version up in knownVersions.
+func versionStatus(version string) (versionRange, error) {
+	v, err := parseEngineVersion(version)
+	if err != nil {
+		return versionRange{}, err
+	}
+	for _, r := range knownVersions {
+		if r.from != "" {
+			from, _ := parseEngineVersion(r.from)
+			if v.less(from) {
+				continue
+			}
+		}
+		if r.before != "" {
+			before, _ := parseEngineVersion(r.before)
+			if !v.less(before) {
+				continue
+			}
+		}
+		return r, nil
+	}
+	return versionRange{status: versionUntested}, nil
+}
+
+// checkEngineVersion prints an advisory for the engine's version, and
+// with --strict-version-check skips the run when the version is known
+// not to hang.
+func checkEngineVersion(version string) {
+	known, err := versionStatus(version)
+	if err != nil {
+		logger.WithError(err).Warn("Could not check engine version against known versions")
+		return
+	}
+	results.setVersionStatus(known.status)
+	vlog := logger.WithField("version_status", known.status)
+	switch known.status {
+	case versionAffected:
+		vlog.Infof("Engine %s is known to hang (%s)", version, known.note)
+	case versionUntested:
+		vlog.Infof("Engine %s hasn't been tested, please report the result", version)
+	case versionUnaffected:
+		if strictVersionCheck {
+			exit(3, fmt.Sprintf("INCONCLUSIVE: skipped, engine %s is known not to hang (%s)", version, known.note))
+		}
+		vlog.Warnf("Engine %s is known not to hang (%s), a pass says nothing new", version, known.note)
+	}
+}
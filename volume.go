@@ -0,0 +1,92 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// volumeMountPath is where each container's volume is mounted.
+const volumeMountPath = "/volume"
+
+var (
+	// volumeOps gives each container a volume of its own, created
+	// before it and removed after it, to take the daemon's volume
+	// subsystem into the calls that may hang.
+	volumeOps bool
+
+	volumesMu sync.Mutex
+	// containerVolumes are the volumes of the containers, by ID.
+	containerVolumes = map[string]string{}
+)
+
+// createVolume creates a volume for a container, labeled with the run's
+// ID, and has it removed on teardown if the run doesn't get to it.
+func createVolume(client *docker.Client) (string, error) {
+	var volume *docker.Volume
+	err := resourceCall("volume-create", func(ctx context.Context) (err error) {
+		volume, err = client.CreateVolume(docker.CreateVolumeOptions{
+			Context: ctx,
+			Labels:  map[string]string{runLabel: runID},
+		})
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	logger.WithField("volume", volume.Name).Debug("Created volume")
+	onTeardown(phaseNetworksVolumes, "remove volume "+volume.Name, func(context.Context) error {
+		err := client.RemoveVolume(volume.Name)
+		if err == docker.ErrNoSuchVolume {
+			return nil
+		}
+		return err
+	})
+	return volume.Name, nil
+}
+
+// setVolume records the volume the container was created with.
+func setVolume(id, volume string) {
+	volumesMu.Lock()
+	defer volumesMu.Unlock()
+	containerVolumes[id] = volume
+}
+
+// volumeOf returns the volume of the container, "" if it has none.
+func volumeOf(id string) string {
+	volumesMu.Lock()
+	defer volumesMu.Unlock()
+	return containerVolumes[id]
+}
+
+// removeVolume removes the volume of cont once cont is removed, bounded
+// by timeout.
+func removeVolume(client *docker.Client, cont *docker.Container, volume string, timeout time.Duration) error {
+	olog, err := timedOp(client, cont, "volume-remove", timeout, func(context.Context) error {
+		return client.RemoveVolume(volume)
+	})
+	olog = olog.WithField("volume", volume)
+	if err != nil {
+		olog.Error("Could not remove volume")
+		return err
+	}
+	olog.Info("Removed volume")
+	return nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// hungCall is a daemon call that outlived its timeout. The watchdog
+// keeps waiting on it to tell a slow daemon apart from one that is
+// permanently wedged.
+type hungCall struct {
+	ContainerID string        `json:"container_id"`
+	Op          string        `json:"op"`
+	Start       time.Time     `json:"start"`
+	Timeout     time.Duration `json:"timeout"`
+	// Returned is false if the call was still outstanding when the run
+	// ended, in which case ReturnedAfter is how long it had been
+	// running by then.
+	Returned      bool          `json:"returned"`
+	ReturnedAfter time.Duration `json:"returned_after"`
+	Error         string        `json:"error,omitempty"`
+}
+
+// watchCall runs fn and stops waiting on it after timeout, returning a
+// callTimeoutError. Unlike a context timeout, the call itself isn't
+// abandoned: a watchdog waits for it to come back and records how long
+// it really took, or that it never did within the run.
+func watchCall(ctx context.Context, containerID, op string, timeout time.Duration, fn func(ctx context.Context) error) error {
+	start := time.Now()
+	done := make(chan error, 1)
+	goSafe(func() {
+		done <- fn(ctx)
+	})
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+	}
+
+	call := results.addHungCall(containerID, op, start, timeout)
+	goSafe(func() {
+		err := <-done
+		d := results.hungCallReturned(call, err)
+		wlog := logger.WithFields(logrus.Fields{
+			"container_id": containerID,
+			"operation":    op,
+			"duration":     d,
+		})
+		if err != nil {
+			wlog = wlog.WithError(err)
+		}
+		wlog.Warn("Hung call returned")
+	})
+	return &callTimeoutError{timeout: timeout}
+}
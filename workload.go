@@ -0,0 +1,183 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/sirupsen/logrus"
+)
+
+// workloadPort is the port the containers of the http-probe scenario
+// listen on, published on a random port of the host's loopback. Only a
+// daemon on this host can be probed there.
+const workloadPort docker.Port = "8080/tcp"
+
+// workloadProbeInterval is how often the http-probe scenario's
+// listeners are probed, each probe bounded by it too.
+var workloadProbeInterval time.Duration
+
+// workloadProbe is a request made to a container's listener from the
+// host, which tells whether the workload answers while its daemon calls
+// hang.
+type workloadProbe struct {
+	Time     time.Time     `json:"time"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// workloadCommand is the command the containers of the http-probe
+// scenario run: busybox's httpd, serving a single page.
+func workloadCommand() []string {
+	return []string{"sh", "-c", fmt.Sprintf("echo ok > /tmp/index.html; exec httpd -f -p %s -h /tmp", workloadPort.Port())}
+}
+
+// workloadAddr looks up the host address cont's listener is published
+// on, with an inspect bounded by the call timeout. The inspect is
+// recorded as its own op, so it doesn't skew the inspect latencies.
+func workloadAddr(client *docker.Client, cont *docker.Container) (string, error) {
+	var insp *docker.Container
+	_, err := timedOp(client, cont, "workload-port", time.Duration(callTimeoutSecs)*time.Second, func(ctx context.Context) (err error) {
+		insp, err = client.InspectContainerWithContext(cont.ID, ctx)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if insp.NetworkSettings != nil {
+		for _, b := range insp.NetworkSettings.Ports[workloadPort] {
+			if b.HostPort != "" {
+				return net.JoinHostPort("127.0.0.1", b.HostPort), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("port %s of container %s isn't published", workloadPort, cont.ID)
+}
+
+// probeWorkloads requests the page of each of conts' listeners every
+// interval until ctx is done, and records whether they answered.
+func probeWorkloads(ctx context.Context, client *docker.Client, conts []*docker.Container, interval time.Duration) {
+	hc := &http.Client{Timeout: interval}
+	var wg sync.WaitGroup
+	for _, cont := range conts {
+		cont := cont
+		wg.Add(1)
+		goSafe(func() {
+			defer wg.Done()
+			clog := logger.WithField("container_id", cont.ID)
+			addr, err := workloadAddr(client, cont)
+			if err != nil {
+				clog.WithError(err).Warn("Could not find the container's listener, not probing it")
+				return
+			}
+			for {
+				if err := sleepCtx(ctx, interval); err != nil {
+					return
+				}
+				start := time.Now()
+				err := getPage(ctx, hc, "http://"+addr+"/")
+				if ctx.Err() != nil {
+					return
+				}
+				results.recordWorkloadProbe(cont.ID, start, err)
+				if err != nil {
+					clog.WithFields(logrus.Fields{
+						"duration": time.Since(start),
+					}).WithError(err).Debug("Workload didn't answer")
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// getPage requests url and reads its body.
+func getPage(ctx context.Context, hc *http.Client, url string) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := hc.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(ioutil.Discard, resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// failedWorkloadProbes returns how many of c's workload probes weren't
+// answered.
+func (c *containerResult) failedWorkloadProbes() int {
+	failed := 0
+	for _, p := range c.WorkloadProbes {
+		if p.Error != "" {
+			failed++
+		}
+	}
+	return failed
+}
+
+// workloadAfterHang returns how many of c's workload probes made
+// between its first hang and its kill answered, of how many were made.
+// Probes after the kill say nothing, the workload is meant to be gone.
+func (c *containerResult) workloadAfterHang(firstHang time.Time) (answered, probed int) {
+	var killed time.Time
+	for _, op := range c.Ops {
+		if op.Op == "kill" || op.Op == "stop" {
+			killed = op.Start
+			break
+		}
+	}
+	for _, p := range c.WorkloadProbes {
+		if p.Time.Before(firstHang) || !killed.IsZero() && p.Time.After(killed) {
+			continue
+		}
+		probed++
+		if p.Error == "" {
+			answered++
+		}
+	}
+	return answered, probed
+}
+
+// checkWorkloadProbe checks the http-probe scenario can be run: the
+// daemon has to be on this host for its published port to be probed,
+// and the image has to be the generated one, whose busybox has httpd.
+func checkWorkloadProbe() error {
+	if host := os.Getenv("DOCKER_HOST"); host != "" && !strings.HasPrefix(host, "unix://") {
+		return fmt.Errorf("scenario %q probes a port published on this host, the daemon at %s is not on it", scenarioHTTPProbe, host)
+	}
+	if imageRef != "" || dockerfilePath != "" || baseImage != defaultBaseImage {
+		return fmt.Errorf("scenario %q needs busybox's httpd, run it with the generated test image on the default base image", scenarioHTTPProbe)
+	}
+	return nil
+}